@@ -2,33 +2,61 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"github.com/adshao/go-binance/v2"
 	"github.com/martinlindhe/notify"
 	"github.com/pkg/errors"
 	"github.com/shopspring/decimal"
+	"github.com/vadiminshakov/marti/config"
 	"github.com/vadiminshakov/marti/entity"
 	"github.com/vadiminshakov/marti/services"
 	"github.com/vadiminshakov/marti/services/anomalydetector"
 	"github.com/vadiminshakov/marti/services/channel"
 	"github.com/vadiminshakov/marti/services/detector"
+	"github.com/vadiminshakov/marti/services/logdedup"
 	binancepricer "github.com/vadiminshakov/marti/services/pricer"
+	"github.com/vadiminshakov/marti/services/symbolstatus"
 	binancetrader "github.com/vadiminshakov/marti/services/trader"
+	"github.com/vadiminshakov/marti/services/turnover"
 	"go.uber.org/zap"
+	"math/rand"
 	"time"
 )
 
+// symbolStatusInterval is how often trading status of the pair is checked on exchange.
+const symbolStatusInterval = 10 * time.Minute
+
+// Publisher publishes trade events to external consumers (message queues, analytics).
+type Publisher interface {
+	PublishTradeEvent(te *entity.TradeEvent) error
+}
+
 // binanceTradeServiceCreator creates trade service for binance exchange.
+// Shared pair is traded by several instances, so the account balance of the pair is not the position of the bot.
 func binanceTradeServiceCreator(logger *zap.Logger, wf channel.ChannelFinder,
-	binanceClient *binance.Client, pair entity.Pair, usebalance decimal.Decimal,
-	pollPricesInterval time.Duration) (func(context.Context) error, error) {
+	binanceClient *binance.Client, conf config.Config, sharedPair bool, publisher Publisher,
+	exposure *services.ExposureTracker, dailyLoss *services.DailyLossTracker, turnoverTracker *turnover.Tracker,
+	limiter *cycleLimiter, status *services.BotStatus, walCfg services.WalConfig) (func(context.Context) error, error) {
+	pair := conf.Pair
+	intervals := pollIntervals{
+		base:       conf.PollPriceInterval,
+		flat:       conf.PollIntervalFlat,
+		inPosition: conf.PollIntervalInPosition,
+	}
+	if conf.MinPollInterval > 0 {
+		intervals.adaptive = newAdaptivePoll(conf.MinPollInterval, conf.MaxPollInterval, conf.PollPriceInterval)
+	}
 	pricer := binancepricer.NewPricer(binanceClient)
 
 	buyprice, channel, err := wf.GetTradingChannel()
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to find window for %s", pair.String())
 	}
+	if wf.Degraded() {
+		logger.Warn("trading channel is calculated over klines with gaps", zap.String("pair", pair.String()))
+	}
 
-	detect, err := detector.NewDetector(binanceClient, usebalance, pair, buyprice, channel)
+	detect, err := detector.NewDetector(binanceClient, conf.Usebalance, pair, buyprice, channel)
 	if err != nil {
 		return nil, err
 	}
@@ -37,20 +65,22 @@ func binanceTradeServiceCreator(logger *zap.Logger, wf channel.ChannelFinder,
 	if err != nil {
 		return nil, err
 	}
+	trader.SetClientOrderPrefix(conf.ClientOrderPrefix)
+	trader.SetInstanceID(conf.InstanceID)
 
 	res, err := binanceClient.NewGetAccountService().Do(context.Background())
 	if err != nil {
 		return nil, err
 	}
 
-	var balanceFirstCurrency decimal.Decimal
+	var baseBalance decimal.Decimal
 	var balanceSecondCurrency decimal.Decimal
 	for _, b := range res.Balances {
 		if b.Asset == pair.To {
 			balanceSecondCurrency, _ = decimal.NewFromString(b.Free)
 		}
 		if b.Asset == pair.From {
-			balanceFirstCurrency, _ = decimal.NewFromString(b.Free)
+			baseBalance, _ = decimal.NewFromString(b.Free)
 		}
 	}
 
@@ -59,17 +89,17 @@ func binanceTradeServiceCreator(logger *zap.Logger, wf channel.ChannelFinder,
 		return nil, err
 	}
 
-	percent := usebalance.Div(decimal.NewFromInt(100))
+	percent := conf.Usebalance.Div(decimal.NewFromInt(100))
 
+	balanceSecondCurrency = quoteCapital(balanceSecondCurrency, conf.MinQuoteReserve, conf.PairBudget)
 	balanceSecondCurrency = balanceSecondCurrency.Div(price)
 	balanceSecondCurrency = balanceSecondCurrency.Mul(percent)
 
 	balanceSecondCurrency = balanceSecondCurrency.RoundFloor(5) // round down to 0,000x
 
 	amount := balanceSecondCurrency
-	if detect.LastAction() == entity.ActionBuy {
-		balanceFirstCurrency = balanceFirstCurrency.RoundFloor(5)
-		amount = balanceFirstCurrency
+	if detect.LastAction() == entity.ActionBuy && !sharedPair {
+		amount = baseBalance.RoundFloor(5)
 	}
 
 	logger.Info("start",
@@ -79,25 +109,149 @@ func binanceTradeServiceCreator(logger *zap.Logger, wf channel.ChannelFinder,
 
 	anomdetector := anomalydetector.NewAnomalyDetector(pair, 30, decimal.NewFromInt(3))
 
-	ts, err := services.NewTradeService(logger, pair, amount, pricer, detect, trader, anomdetector)
+	var tsTrader services.Trader = trader
+	if turnoverTracker != nil {
+		limits := turnover.Limits{Hourly: conf.MaxHourlyTurnover, Daily: conf.MaxDailyTurnover}
+		tsTrader = turnover.NewTrader(logger, trader, pricer, pair, entity.BotID(pair, conf.InstanceID), limits, turnoverTracker)
+	}
+
+	// trade execution is never collapsed by log deduplication
+	tradeLogger := logger.Named(logdedup.ExemptLogger)
+	ts, err := services.NewTradeService(tradeLogger, pair, amount, pricer, detect, tsTrader, anomdetector, exposure, walCfg)
 	if err != nil {
 		return nil, err
 	}
 
+	ts.SetInstanceID(conf.InstanceID)
+	ts.SetQuoteReserve(conf.MinQuoteReserve)
+	ts.SetInitialBuyNotional(conf.InitialBuyNotional)
+	if conf.SizeJitterPercent.IsPositive() {
+		ts.SetSizeJitter(conf.SizeJitterPercent, rand.NewSource(time.Now().UnixNano()))
+	}
+	// base balance of shared pair holds positions of other instances too, so the position is taken from own WAL
+	held := amount
+	if sharedPair {
+		held = ts.Position()
+		lastAction := entity.ActionSell
+		if held.IsPositive() {
+			lastAction = entity.ActionBuy
+		}
+		detect.SetLastAction(lastAction)
+		if conf.ReconcileFromExchange {
+			logger.Warn("position is not reconciled with exchange balance, the pair is traded by several instances",
+				zap.String("bot", entity.BotID(pair, conf.InstanceID)))
+		}
+	} else if err := ts.ReconcilePosition(baseBalance, price, conf.ReconcileFromExchange); err != nil {
+		ts.Close()
+		return nil, err
+	}
+
+	if exposure != nil {
+		deployed := decimal.Zero
+		if detect.LastAction() == entity.ActionBuy {
+			deployed = held.Mul(price)
+		}
+		exposure.Set(entity.BotID(pair, conf.InstanceID), deployed)
+		logger.Info("global exposure", zap.String("deployed percent", exposure.DeployedPercent().StringFixed(2)))
+	}
+	if dailyLoss != nil {
+		notional := decimal.Zero
+		if detect.LastAction() == entity.ActionBuy {
+			notional = held.Mul(price)
+		}
+		dailyLoss.Set(entity.BotID(pair, conf.InstanceID), notional)
+		ts.SetDailyLoss(dailyLoss)
+	}
+	ts.SetMaxPriceAge(conf.MaxPriceAge)
+	if conf.AmountRoundDecimals != nil {
+		ts.SetAmountRounding(*conf.AmountRoundDecimals)
+	}
+	if conf.MaxSlippagePercent.IsPositive() {
+		ts.SetMaxSlippage(pricer, conf.MaxSlippagePercent)
+	}
+	if conf.TrailingStopPercent.IsPositive() {
+		ts.SetTrailingStop(trader, conf.TrailingStopPercent)
+	}
+
+	statusChecker := symbolstatus.NewBinanceChecker(binanceClient, pair)
+	checkSymbolStatus(logger, statusChecker, ts, pair)
+
 	return func(ctx context.Context) error {
-		t := time.NewTicker(pollPricesInterval)
+		defer ts.Close()
+		interval := intervals.next(ts.InPosition())
+		t := time.NewTicker(interval)
+		statusTicker := time.NewTicker(symbolStatusInterval)
+		defer statusTicker.Stop()
+		trade := limiter.wrap(ts.Trade)
+		wd := newWatchdog(interval*time.Duration(conf.HangTimeoutMultiplier), conf.MaxHangs, limiter, status)
+		// WAL is closed and the bot is recreated only after the hung cycle completes, so its late fill is saved
+		// and seen by the new bot
+		defer drainHungCycle(tradeLogger, ts, wd, publisher, pair)
+		var paused bool
 		for ctx.Err() == nil {
 			select {
+			case <-statusTicker.C:
+				checkSymbolStatus(logger, statusChecker, ts, pair)
 			case <-t.C:
-				te, err := ts.Trade()
+				window, inWindow := activeTimeWindow(conf.NoTradeWindows, time.Now())
+				if inWindow != paused {
+					paused = inWindow
+					if paused {
+						logger.Info("no-trade window started, trading is paused",
+							zap.String("pair", pair.String()), zap.String("window", window.String()))
+					} else {
+						logger.Info("no-trade window ended, trading is resumed", zap.String("pair", pair.String()))
+					}
+				}
+				if paused {
+					continue
+				}
+
+				var te *entity.TradeEvent
+				var err error
+				if conf.HangTimeoutMultiplier > 0 {
+					wd.timeout = interval * time.Duration(conf.HangTimeoutMultiplier)
+					var hung bool
+					te, hung, err = wd.run(ctx, ts.Trade)
+					if ctx.Err() != nil {
+						t.Stop()
+						return ctx.Err()
+					}
+					if hung {
+						logger.Error("trade cycle hangs", zap.String("pair", pair.String()),
+							zap.String("phase", ts.Phase().String()), zap.Duration("running", wd.running()),
+							zap.Int("consecutive hangs", wd.hangs), zap.Int64("total hangs", status.Hangs()))
+						if wd.escalate() {
+							notify.Alert("marti", "alert", fmt.Sprintf("trade cycle of %s hangs (%d hangs since start), recreate bot",
+								pair.String(), status.Hangs()), "")
+							t.Stop()
+							return errors.Wrapf(errTradeHung, "%s stuck in %s phase", pair.String(), ts.Phase())
+						}
+						continue
+					}
+				} else {
+					te, err = trade()
+				}
 				if err != nil {
 					notify.Alert("marti", "alert", err.Error(), "")
 					t.Stop()
 					return err
 				}
 				if te != nil {
-					logger.Info(te.String())
+					tradeLogger.Info(te.String())
 					notify.Alert("marti", "alert", te.String(), "")
+					if publisher != nil {
+						go publishTradeEvent(logger, publisher, te)
+					}
+				}
+
+				if intervals.adaptive != nil {
+					intervals.adaptive.observe(ts.LastPrice())
+				}
+				if next := intervals.next(ts.InPosition()); next != interval {
+					interval = next
+					t.Reset(interval)
+					logger.Info("poll interval changed", zap.String("pair", pair.String()), zap.Duration("interval", interval))
 				}
 			case <-ctx.Done():
 				t.Stop()
@@ -108,3 +262,118 @@ func binanceTradeServiceCreator(logger *zap.Logger, wf channel.ChannelFinder,
 		return ctx.Err()
 	}, nil
 }
+
+// quoteCapital returns quote balance usebalance percent is applied to: balance above the reserve,
+// capped by the pair budget if it is set.
+func quoteCapital(balance, reserve, budget decimal.Decimal) decimal.Decimal {
+	capital := decimal.Max(balance.Sub(reserve), decimal.Zero)
+	if budget.IsPositive() {
+		capital = decimal.Min(capital, budget)
+	}
+
+	return capital
+}
+
+// totalCapital returns total balance of quote currencies plus value of total base currencies of all pairs
+// in quote currency. Total balance includes funds locked in open orders. All pairs are expected to be quoted
+// in the same currency.
+func totalCapital(client *binance.Client, configs []config.Config) (decimal.Decimal, error) {
+	res, err := client.NewGetAccountService().Do(context.Background())
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+
+	balances := make(map[string]decimal.Decimal, len(res.Balances))
+	for _, b := range res.Balances {
+		free, _ := decimal.NewFromString(b.Free)
+		locked, _ := decimal.NewFromString(b.Locked)
+		balances[b.Asset] = entity.Balance{Free: free, Locked: locked}.Total()
+	}
+
+	pricer := binancepricer.NewPricer(client)
+	counted := make(map[string]struct{})
+	capital := decimal.Zero
+	for _, c := range configs {
+		if _, ok := counted[c.Pair.To]; !ok {
+			counted[c.Pair.To] = struct{}{}
+			capital = capital.Add(balances[c.Pair.To])
+		}
+
+		if _, ok := counted[c.Pair.From]; ok {
+			continue
+		}
+		counted[c.Pair.From] = struct{}{}
+
+		price, err := pricer.GetPrice(c.Pair)
+		if err != nil {
+			return decimal.Decimal{}, errors.Wrapf(err, "failed to get price for %s", c.Pair.String())
+		}
+		capital = capital.Add(balances[c.Pair.From].Mul(price))
+	}
+
+	return capital, nil
+}
+
+// activeTimeWindow returns the window containing now if any.
+func activeTimeWindow(windows []entity.TimeWindow, now time.Time) (entity.TimeWindow, bool) {
+	for _, w := range windows {
+		if w.Contains(now) {
+			return w, true
+		}
+	}
+
+	return entity.TimeWindow{}, false
+}
+
+// checkSymbolStatus pauses buys when trading on the pair is halted by exchange and resumes them
+// when trading is back, every transition is alerted.
+func checkSymbolStatus(logger *zap.Logger, checker *symbolstatus.BinanceChecker, ts *services.TradeService, pair entity.Pair) {
+	status, err := checker.Status()
+	if err != nil {
+		logger.Warn("failed to check symbol status", zap.String("pair", pair.String()), zap.Error(err))
+		return
+	}
+
+	if status.Halted() == ts.Halted() {
+		return
+	}
+	ts.SetHalted(status.Halted())
+
+	msg := fmt.Sprintf("trading on %s is resumed", pair.String())
+	if status.Halted() {
+		msg = fmt.Sprintf("trading on %s is halted (status %s), buys are paused", pair.String(), status)
+		logger.Error(msg)
+	} else {
+		logger.Info(msg)
+	}
+	notify.Alert("marti", "alert", msg, "")
+}
+
+// drainHungCycle stops the service from placing new orders and waits for the abandoned cycle to complete.
+func drainHungCycle(logger *zap.Logger, ts *services.TradeService, wd *watchdog, publisher Publisher, pair entity.Pair) {
+	if wd.pending == nil {
+		return
+	}
+
+	ts.Stop()
+	logger.Warn("wait for hung trade cycle to complete", zap.String("pair", pair.String()),
+		zap.String("phase", ts.Phase().String()), zap.Duration("running", wd.running()))
+	te, _, err := wd.wait()
+	if err != nil && !errors.Is(err, services.ErrStopped) {
+		logger.Error("hung trade cycle failed", zap.String("pair", pair.String()), zap.Error(err))
+	}
+	if te != nil {
+		logger.Info(te.String())
+		notify.Alert("marti", "alert", te.String(), "")
+		if publisher != nil {
+			go publishTradeEvent(logger, publisher, te)
+		}
+	}
+}
+
+// publishTradeEvent publishes trade event, failures are logged and never interrupt trading.
+func publishTradeEvent(logger *zap.Logger, publisher Publisher, te *entity.TradeEvent) {
+	if err := publisher.PublishTradeEvent(te); err != nil {
+		logger.Warn("failed to publish trade event", zap.String("event", te.String()), zap.Error(err))
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"github.com/vadiminshakov/marti/config"
+	"github.com/vadiminshakov/marti/entity"
+)
+
+func TestQuoteCapitalPairBudget(t *testing.T) {
+	balance, reserve := decimal.NewFromInt(10000), decimal.NewFromInt(500)
+
+	require.Equal(t, "9500", quoteCapital(balance, reserve, decimal.Zero).String(), "no budget means whole balance above reserve")
+
+	// 10 pairs sharing the balance are sized against their budgets, not the whole balance
+	capital := quoteCapital(balance, reserve, decimal.NewFromInt(1000))
+	require.Equal(t, "1000", capital.String())
+	require.Equal(t, "500", capital.Mul(decimal.NewFromInt(50)).Div(decimal.NewFromInt(100)).String())
+
+	require.Equal(t, "300", quoteCapital(decimal.NewFromInt(800), reserve, decimal.NewFromInt(1000)).String(),
+		"budget is capped by the balance above reserve")
+}
+
+func TestTotalCapitalIncludesLockedFunds(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/account":
+			_, _ = w.Write([]byte(`{"balances":[{"asset":"BTC","free":"0.5","locked":"0.5"},{"asset":"USDT","free":"100","locked":"400"}]}`))
+		case "/api/v3/ticker/price":
+			_, _ = w.Write([]byte(`[{"symbol":"BTCUSDT","price":"1000"}]`))
+		default:
+			t.Fatalf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	client := binance.NewClient("key", "secret")
+	client.BaseURL = srv.URL
+
+	capital, err := totalCapital(client, []config.Config{{Pair: entity.Pair{From: "BTC", To: "USDT"}}})
+	require.NoError(t, err)
+	// 100 + 400 USDT and (0.5 + 0.5) BTC * 1000
+	require.Equal(t, "1500", capital.String())
+}
+
+func TestSharedPairs(t *testing.T) {
+	btc := entity.Pair{From: "BTC", To: "USDT"}
+	eth := entity.Pair{From: "ETH", To: "USDT"}
+	shared := sharedPairs([]config.Config{
+		{Pair: btc},
+		{Pair: btc, InstanceID: "fast"},
+		{Pair: eth},
+	})
+
+	require.True(t, shared[btc.String()])
+	require.False(t, shared[eth.String()])
+}
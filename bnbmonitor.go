@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/martinlindhe/notify"
+	"github.com/vadiminshakov/marti/services/bnbfee"
+	"go.uber.org/zap"
+)
+
+const (
+	// bnbCheckInterval is how often BNB balance paying trading fees is checked.
+	bnbCheckInterval = 10 * time.Minute
+	// bnbTopUpInstance isolates WAL of BNB top-ups from WAL of a bot trading the same pair.
+	bnbTopUpInstance = "feetopup"
+)
+
+// watchBNBBalance checks BNB balance paying trading fees every interval. Nothing is watched
+// if paying fees in BNB is disabled for the account.
+func watchBNBBalance(logger *zap.Logger, monitor *bnbfee.Monitor, interval time.Duration) {
+	enabled, err := monitor.FeesInBNB()
+	if err != nil {
+		logger.Warn("failed to check whether trading fees are paid in BNB", zap.Error(err))
+	} else if !enabled {
+		logger.Info("paying trading fees in BNB is disabled, BNB balance is not monitored")
+		return
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	var low bool
+	for {
+		low = checkBNBBalance(logger, monitor, low)
+		<-t.C
+	}
+}
+
+// checkBNBBalance checks BNB balance, alerting when it becomes low and on every top-up.
+// Returns whether the balance is low.
+func checkBNBBalance(logger *zap.Logger, monitor *bnbfee.Monitor, wasLow bool) bool {
+	status, err := monitor.Check()
+	if err != nil {
+		logger.Error("failed to check BNB balance", zap.Error(err))
+		notify.Alert("marti", "alert", err.Error(), "")
+		return status.Low
+	}
+
+	if status.Event != nil {
+		msg := fmt.Sprintf("BNB balance %s is low, fee top-up bought %s BNB", status.Balance.String(), status.ToppedUp.String())
+		logger.Info(status.Event.String())
+		notify.Alert("marti", "alert", msg, "")
+		return false
+	}
+
+	if status.Low && !wasLow {
+		msg := fmt.Sprintf("BNB balance %s is low, trading fees will be taken from traded assets once it runs out",
+			status.Balance.String())
+		logger.Warn(msg)
+		notify.Alert("marti", "alert", msg, "")
+	}
+
+	return status.Low
+}
@@ -6,93 +6,565 @@ import (
 	"github.com/shopspring/decimal"
 	"github.com/vadiminshakov/marti/entity"
 	"gopkg.in/yaml.v3"
+	"net/url"
 	"os"
 	"strings"
 	"time"
 )
 
+const (
+	// defaultMaxPriceAge is max age of the price orders are sent at if it is not configured, zero disables the check.
+	defaultMaxPriceAge time.Duration = 0
+	// defaultHangTimeoutMultiplier is the number of poll intervals a trade cycle may run if it is not configured,
+	// zero disables the watchdog.
+	defaultHangTimeoutMultiplier = 0
+	// defaultClientOrderPrefix is prepended to client order ids if no other prefix is configured.
+	defaultClientOrderPrefix = "marti-"
+	// defaultWalSync syncs every WAL write, it is the only mode which never loses fills of live trading on crash.
+	defaultWalSync = "always"
+	// defaultSubjectPrefix is prepended to NATS subjects if no other prefix is configured.
+	defaultSubjectPrefix = "marti"
+	// maxClientOrderPrefixLen leaves room for unique part of client order ids within exchange limits.
+	maxClientOrderPrefixLen = 16
+	// defaultMaxHangs is the number of consecutive missed deadlines after which a hung bot is recreated.
+	defaultMaxHangs = 3
+)
+
 type Config struct {
-	Pair              entity.Pair
+	Pair entity.Pair
+	// InstanceID isolates state of bots trading the same pair, empty for the only bot of the pair.
+	InstanceID        string
 	StatHours         uint64
 	Usebalance        decimal.Decimal
 	MinChannel        decimal.Decimal
 	RebalanceInterval time.Duration
 	PollPriceInterval time.Duration
+	// NatsURL is the NATS server address trade events are published to, publishing is disabled if empty.
+	NatsURL string
+	// SubjectPrefix is prepended to the NATS subject of every published event.
+	SubjectPrefix string
+	// PollIntervalFlat overrides PollPriceInterval while the bot holds no position.
+	PollIntervalFlat time.Duration
+	// PollIntervalInPosition overrides PollPriceInterval while the bot holds a position.
+	PollIntervalInPosition time.Duration
+	// MinPollInterval and MaxPollInterval enable adaptive poll interval following price volatility within the bounds,
+	// it overrides the other poll intervals. Both are zero if adaptive interval is disabled.
+	MinPollInterval time.Duration
+	MaxPollInterval time.Duration
+	// AllowKlineGaps allows calculating trading channel over klines with gaps which can't be backfilled,
+	// the channel is marked as degraded then. It is allowed by default.
+	AllowKlineGaps bool
+	// MinKlines is the min number of klines required to calculate trading channel, the bot waits while there are fewer.
+	MinKlines int
+	// ZeroVolumeHandling defines how klines without trades are used for trading channel calculation.
+	ZeroVolumeHandling entity.ZeroVolumeHandling
+	// WalSync defines when WAL writes are synced to disk. Bots trade live, so it is always "always",
+	// relaxed modes are meant for simulations embedding the engine (see services.WalConfig).
+	WalSync string
+	// KlineInterval is the interval of klines the trading channel is calculated over, 4h if zero.
+	// Intervals not provided by exchange are aggregated from smaller klines.
+	KlineInterval time.Duration
+	// SizeJitterPercent randomizes every buy amount by up to ±percent around the computed size, zero disables it.
+	SizeJitterPercent decimal.Decimal
+	// MinQuoteReserve is quote balance which is never spent, usebalance percent is applied to balance above it.
+	MinQuoteReserve decimal.Decimal
+	// InitialBuyNotional is exact quote amount of the first buy of every series, zero means it is derived from usebalance.
+	InitialBuyNotional decimal.Decimal
+	// PairBudget is quote amount the bot treats as its total capital, usebalance percent is applied to it
+	// instead of the whole balance. Zero means no budget.
+	PairBudget decimal.Decimal
+	// AmountRoundDecimals is the number of decimals every buy amount is rounded down to, nil disables rounding.
+	AmountRoundDecimals *int32
+	// ReconcileFromExchange makes the bot take base balance on exchange as its position on start
+	// if they differ significantly, otherwise the mismatch is only logged.
+	ReconcileFromExchange bool
+	// MaxPriceAge is max age of the price orders are sent at, older price is fetched again before sending.
+	// Zero disables the check.
+	MaxPriceAge time.Duration
+	// MaxSlippagePercent is max percent market orders may be filled off the decided price by best order book price,
+	// orders with higher projected slippage are aborted. Zero disables the check.
+	MaxSlippagePercent decimal.Decimal
+	// TrailingStopPercent is callback percent of exchange-native trailing stop placed after every buy, zero disables it.
+	TrailingStopPercent decimal.Decimal
+	// NoTradeWindows are daily time ranges the bot doesn't trade in.
+	NoTradeWindows []entity.TimeWindow
+	// MaxHourlyTurnover and MaxDailyTurnover are max quote notional traded by the bot within an hour and a day,
+	// buys beyond them are blocked. Zero means no limit.
+	MaxHourlyTurnover decimal.Decimal
+	MaxDailyTurnover  decimal.Decimal
+	// ClientOrderPrefix is prepended to client order ids making orders of the bot recognizable on exchange.
+	ClientOrderPrefix string
+	// HangTimeoutMultiplier is the number of poll intervals a trade cycle may run before it is reported as hung,
+	// zero disables the watchdog.
+	HangTimeoutMultiplier int
+	// MaxHangs is the number of consecutive missed deadlines after which the bot is recreated.
+	MaxHangs int
 }
 
 type ConfigTmp struct {
-	Pair              string
-	StatHours         uint64
-	Usebalance        string
-	MinChannel        string
-	RebalanceInterval time.Duration
-	PollPriceInterval time.Duration
+	Pair                   string
+	InstanceID             string `yaml:"instance_id"`
+	StatHours              uint64
+	Usebalance             string
+	MinChannel             string
+	RebalanceInterval      time.Duration
+	PollPriceInterval      time.Duration
+	KlineInterval          time.Duration
+	NatsURL                string  `yaml:"nats_url"`
+	SubjectPrefix          *string `yaml:"subject_prefix"`
+	AllowKlineGaps         *bool
+	MinKlines              int
+	ZeroVolumeHandling     string         `yaml:"zero_volume_handling"`
+	WalSync                string         `yaml:"wal_sync"`
+	PollIntervalFlat       time.Duration  `yaml:"poll_interval_flat"`
+	PollIntervalInPosition time.Duration  `yaml:"poll_interval_in_position"`
+	MinPollInterval        time.Duration  `yaml:"min_poll_interval"`
+	MaxPollInterval        time.Duration  `yaml:"max_poll_interval"`
+	NoTradeWindows         []string       `yaml:"no_trade_windows"`
+	SizeJitterPercent      string         `yaml:"size_jitter_percent"`
+	MinQuoteReserve        string         `yaml:"min_quote_reserve"`
+	PairBudget             string         `yaml:"pair_budget"`
+	InitialBuyNotional     string         `yaml:"initial_buy_notional"`
+	TrailingStopPercent    string         `yaml:"trailing_stop_percent"`
+	MaxSlippagePercent     string         `yaml:"max_slippage_percent"`
+	AmountRoundDecimals    *int32         `yaml:"amount_round_decimals"`
+	ReconcileFromExchange  bool           `yaml:"reconcile_from_exchange"`
+	MaxPriceAge            *time.Duration `yaml:"max_price_age"`
+	ClientOrderPrefix      string         `yaml:"client_order_prefix"`
+	MaxHourlyTurnover      string         `yaml:"max_hourly_turnover"`
+	MaxDailyTurnover       string         `yaml:"max_daily_turnover"`
+	HangTimeoutMultiplier  *int           `yaml:"hang_timeout_multiplier"`
+	MaxHangs               *int           `yaml:"max_hangs"`
+}
+
+// Global holds settings shared by all bots of the process.
+type Global struct {
+	// MaxExposurePercent is the max percent of total capital deployed across all pairs at once, zero means no limit.
+	MaxExposurePercent decimal.Decimal
+	// MaxRequestsPerMinute limits exchange API requests made by all bots, zero means no limit.
+	MaxRequestsPerMinute int
+	// RateLimitFailFast makes requests beyond the budget fail instead of waiting.
+	RateLimitFailFast bool
+	// MaxHourlyTurnover and MaxDailyTurnover are max quote notional traded by all bots within an hour and a day,
+	// buys beyond them are blocked. Zero means no limit.
+	MaxHourlyTurnover decimal.Decimal
+	MaxDailyTurnover  decimal.Decimal
+	// MaxDailyLoss is the max loss of all bots since midnight in quote currency, realized and unrealized. Once it is
+	// exceeded, positions are sold and trading is halted until the next day. Zero means no limit.
+	MaxDailyLoss decimal.Decimal
+	// MaxConcurrentBots limits trade cycles of all bots running at once, zero means no limit.
+	MaxConcurrentBots int
+	// BNBMinBalance is BNB balance paying trading fees below which an alert is raised, zero disables monitoring.
+	BNBMinBalance decimal.Decimal
+	// BNBTopUp is the amount of BNB bought for BNBTopUpQuote currency when BNB balance is low, zero disables top-ups.
+	BNBTopUp      decimal.Decimal
+	BNBTopUpQuote string
+	// LogDedupWindow is the window identical consecutive log lines are collapsed within, zero disables collapsing.
+	LogDedupWindow time.Duration
+	// ValidatePairs enables check on start that configured pairs are listed on the exchange.
+	ValidatePairs bool
+	// OtelEndpoint is the OTLP/HTTP collector url trade cycles are traced to, tracing is disabled if empty.
+	OtelEndpoint string
+	// ResetPair is the pair whose state is removed instead of running bots, nil if not set.
+	ResetPair *entity.Pair
+	// ResetInstanceID is the instance id of the bot whose state is removed.
+	ResetInstanceID string
+	// ResumeTurnover is the bot whose tripped turnover limits are resumed instead of running bots, nil if not set.
+	ResumeTurnover *string
 }
 
-func Get() ([]Config, error) {
-	config := flag.String("config", "", "path to yaml config")
+// cliFlags holds bot settings passed via command line flags.
+type cliFlags struct {
+	pair                   *string
+	instanceID             *string
+	minChannel             *string
+	statHours              *uint64
+	usebalance             *string
+	rebalanceInterval      *time.Duration
+	pollPriceInterval      *time.Duration
+	klineInterval          *time.Duration
+	natsURL                *string
+	subjectPrefix          *string
+	allowKlineGaps         *bool
+	minKlines              *int
+	zeroVolumeHandling     *string
+	walSync                *string
+	pollIntervalFlat       *time.Duration
+	pollIntervalInPosition *time.Duration
+	minPollInterval        *time.Duration
+	maxPollInterval        *time.Duration
+	noTradeWindows         *string
+	sizeJitterPercent      *string
+	minQuoteReserve        *string
+	pairBudget             *string
+	initialBuyNotional     *string
+	trailingStopPercent    *string
+	maxSlippagePercent     *string
+	amountRoundDecimals    *int
+	reconcileFromExchange  *bool
+	maxPriceAge            *time.Duration
+	clientOrderPrefix      *string
+	maxHourlyTurnover      *string
+	maxDailyTurnover       *string
+	hangTimeoutMultiplier  *int
+	maxHangs               *int
+}
+
+func Get() (Global, []Config, error) {
+	config := flag.String("config", "", "path to yaml config, several comma separated configs are merged")
+	maxExposure := flag.String("maxexposure", "0", "max percent of total capital deployed across all pairs at once, 0 means no limit")
+	maxRequests := flag.Int("maxrequestsperminute", 0, "max exchange API requests per minute across all pairs, 0 means no limit")
+	rateLimitFailFast := flag.Bool("ratelimitfailfast", false, "fail requests beyond the API budget instead of waiting")
+	maxHourlyTurnover := flag.String("globalmaxhourlyturnover", "0",
+		"max quote notional traded by all bots within an hour, buys beyond it are blocked, 0 means no limit")
+	maxDailyTurnover := flag.String("globalmaxdailyturnover", "0",
+		"max quote notional traded by all bots within a day, buys beyond it are blocked, 0 means no limit")
+	maxDailyLoss := flag.String("maxdailyloss", "0",
+		"max loss of all bots since midnight in quote currency, positions are sold and trading is halted until the next day beyond it, 0 means no limit")
+	maxConcurrentBots := flag.Int("maxconcurrentbots", 0, "max trade cycles of all bots running at once, the rest are queued, 0 means no limit")
+	bnbMinBalance := flag.String("bnbminbalance", "0", "alert when BNB balance paying trading fees is below it, 0 disables monitoring")
+	bnbTopUp := flag.String("bnbtopup", "0", "amount of BNB bought when BNB balance is below --bnbminbalance, 0 disables top-ups")
+	bnbTopUpQuote := flag.String("bnbtopupquote", "USDT", "currency BNB top-ups are bought for")
+	logDedupWindow := flag.Duration("logdedupwindow", 0,
+		"collapse identical consecutive log lines written within the window, e.g. 15m, 0 disables collapsing")
+	validatePairs := flag.Bool("validatepairs", false, "check on start that configured pairs are listed on the exchange")
+	otelEndpoint := flag.String("otelendpoint", "",
+		"OTLP/HTTP collector url trade cycles are traced to, example: http://localhost:4318, empty disables tracing")
+	resetPair := flag.String("reset-pair", "", "remove state of the pair after confirmation and exit, example: BTC_USDT")
+	resetInstance := flag.String("reset-instance", "", "instance id of the bot whose state is removed by --reset-pair")
+	resumeTurnover := flag.String("resume-turnover", "",
+		"resume buys of the bot blocked by turnover limits and exit, example: BTC_USDT, BTC_USDT-fast or all")
+	cli := defineCLIFlags()
 	flag.Parse()
-	if *config != "" {
-		return getYaml(*config)
+
+	if *resumeTurnover != "" {
+		return Global{ResumeTurnover: resumeTurnover}, nil, nil
+	}
+
+	if *resetPair != "" {
+		pair, err := entity.ParsePair(*resetPair)
+		if err != nil {
+			return Global{}, nil, fmt.Errorf("invalid --reset-pair provided, --reset-pair=%s: %s", *resetPair, err)
+		}
+		if !validInstanceID(*resetInstance) {
+			return Global{}, nil, fmt.Errorf("invalid --reset-instance provided, --reset-instance=%s", *resetInstance)
+		}
+		return Global{ResetPair: &pair, ResetInstanceID: *resetInstance}, nil, nil
 	}
 
-	pair, statHours, usebalance, minwindow, rebalanceInterval, pollPriceInterval, err := getFromCLI()
+	global, err := getGlobal(*maxExposure)
 	if err != nil {
-		return nil, err
+		return Global{}, nil, err
+	}
+	if *maxRequests < 0 {
+		return Global{}, nil, fmt.Errorf("invalid --maxrequestsperminute provided, --maxrequestsperminute=%d", *maxRequests)
+	}
+	global.MaxRequestsPerMinute = *maxRequests
+	global.RateLimitFailFast = *rateLimitFailFast
+	if *maxConcurrentBots < 0 {
+		return Global{}, nil, fmt.Errorf("invalid --maxconcurrentbots provided, --maxconcurrentbots=%d", *maxConcurrentBots)
+	}
+	global.MaxConcurrentBots = *maxConcurrentBots
+	if *logDedupWindow < 0 {
+		return Global{}, nil, fmt.Errorf("invalid --logdedupwindow provided, --logdedupwindow=%s", *logDedupWindow)
+	}
+	global.LogDedupWindow = *logDedupWindow
+	if global.BNBMinBalance, err = parseAmount(*bnbMinBalance); err != nil {
+		return Global{}, nil, fmt.Errorf("invalid --bnbminbalance provided, --bnbminbalance=%s", *bnbMinBalance)
+	}
+	if global.BNBTopUp, err = parseAmount(*bnbTopUp); err != nil {
+		return Global{}, nil, fmt.Errorf("invalid --bnbtopup provided, --bnbtopup=%s", *bnbTopUp)
+	}
+	global.BNBTopUpQuote = *bnbTopUpQuote
+	if global.MaxHourlyTurnover, err = parseAmount(*maxHourlyTurnover); err != nil {
+		return Global{}, nil, fmt.Errorf("invalid --globalmaxhourlyturnover provided, --globalmaxhourlyturnover=%s", *maxHourlyTurnover)
+	}
+	if global.MaxDailyTurnover, err = parseAmount(*maxDailyTurnover); err != nil {
+		return Global{}, nil, fmt.Errorf("invalid --globalmaxdailyturnover provided, --globalmaxdailyturnover=%s", *maxDailyTurnover)
+	}
+	if global.MaxDailyLoss, err = parseAmount(*maxDailyLoss); err != nil {
+		return Global{}, nil, fmt.Errorf("invalid --maxdailyloss provided, --maxdailyloss=%s", *maxDailyLoss)
+	}
+	global.ValidatePairs = *validatePairs
+	if !validOtelEndpoint(*otelEndpoint) {
+		return Global{}, nil, fmt.Errorf("invalid --otelendpoint provided, --otelendpoint=%s", *otelEndpoint)
 	}
+	global.OtelEndpoint = *otelEndpoint
 
-	return []Config{
-		{
-			Pair:              pair,
-			StatHours:         statHours,
-			Usebalance:        usebalance,
-			MinChannel:        minwindow,
-			RebalanceInterval: rebalanceInterval,
-			PollPriceInterval: pollPriceInterval,
-		},
-	}, nil
+	var configs []Config
+	if *config != "" {
+		if configs, err = getYaml(strings.Split(*config, ",")...); err != nil {
+			return Global{}, nil, err
+		}
+	} else {
+		c, err := getFromCLI(cli)
+		if err != nil {
+			return Global{}, nil, err
+		}
+		configs = []Config{c}
+	}
+
+	if err := checkDailyLossQuote(global, configs); err != nil {
+		return Global{}, nil, err
+	}
+
+	return global, configs, nil
 }
 
-func getFromCLI() (pair entity.Pair, hours uint64, usebalance, minChannel decimal.Decimal,
-	rebalanceInterval, pollPriceInterval time.Duration, _ error) {
-	pairFlag := flag.String("pair", "BTC_USDT", "trade pair, example: BTC_USDT")
-	minch := flag.String("minchannel", "100", "min channel size")
-	statH := flag.Uint64("stathours", 5, "hours in past that will be used for stats count, example: 10")
-	useb := flag.String("usebalance", "100", "percent of balance usage, for example 90 means 90%")
-	ri := flag.Duration("rebalanceinterval", 30*time.Hour, "rebalance interval")
-	pi := flag.Duration("pollpriceinterval", 5*time.Minute, "poll market price interval")
+// checkDailyLossQuote checks that all pairs are quoted in the same currency if daily loss is limited,
+// losses in different currencies can't be summed.
+func checkDailyLossQuote(global Global, configs []Config) error {
+	if !global.MaxDailyLoss.IsPositive() {
+		return nil
+	}
+	for _, c := range configs[1:] {
+		if c.Pair.To != configs[0].Pair.To {
+			return fmt.Errorf("--maxdailyloss requires pairs quoted in the same currency, got %s and %s",
+				configs[0].Pair.String(), c.Pair.String())
+		}
+	}
 
-	flag.Parse()
+	return nil
+}
 
-	var err error
-	pair, err = getPairFromString(*pairFlag)
+func getGlobal(maxExposure string) (Global, error) {
+	maxExposurePercent, err := decimal.NewFromString(maxExposure)
 	if err != nil {
-		return entity.Pair{}, 0, decimal.Decimal{}, decimal.Decimal{}, 0, 0, fmt.Errorf("invalid --par provided, --pair=%s", *pairFlag)
+		return Global{}, fmt.Errorf("invalid --maxexposure provided, --maxexposure=%s", maxExposure)
+	}
+	if maxExposurePercent.IsNegative() || maxExposurePercent.GreaterThan(decimal.NewFromInt(100)) {
+		return Global{}, fmt.Errorf("invalid --maxexposure provided, --maxexposure=%s", maxExposure)
 	}
-	usebalance, err = decimal.NewFromString(*useb)
+
+	return Global{MaxExposurePercent: maxExposurePercent}, nil
+}
+
+func defineCLIFlags() cliFlags {
+	return cliFlags{
+		pair:              flag.String("pair", "BTC_USDT", "trade pair, example: BTC_USDT"),
+		instanceID:        flag.String("instanceid", "", "id isolating state of bots trading the same pair, example: fast"),
+		minChannel:        flag.String("minchannel", "100", "min channel size"),
+		statHours:         flag.Uint64("stathours", 5, "hours in past that will be used for stats count, example: 10"),
+		usebalance:        flag.String("usebalance", "100", "percent of balance usage, for example 90 means 90%"),
+		rebalanceInterval: flag.Duration("rebalanceinterval", 30*time.Hour, "rebalance interval"),
+		pollPriceInterval: flag.Duration("pollpriceinterval", 5*time.Minute, "poll market price interval"),
+		klineInterval:     flag.Duration("klineinterval", 4*time.Hour, "interval of klines used for trading channel calculation"),
+		natsURL:           flag.String("natsurl", "", "NATS server url for trade events publishing, example: nats://127.0.0.1:4222"),
+		subjectPrefix:     flag.String("subjectprefix", defaultSubjectPrefix, "NATS subject prefix for trade events"),
+		allowKlineGaps: flag.Bool("allowklinegaps", true,
+			"calculate trading channel over klines with gaps in degraded mode, if false the bot fails and restarts"),
+		minKlines: flag.Int("minklines", 1, "min number of klines required to calculate trading channel"),
+		zeroVolumeHandling: flag.String("zerovolumehandling", string(entity.ZeroVolumeKeep),
+			"how klines without trades are used for trading channel calculation: keep, skip or fill with previous close"),
+		walSync: flag.String("walsync", defaultWalSync,
+			"when WAL writes are synced to disk, live trading requires always, interval and never are for simulations"),
+		pollIntervalFlat: flag.Duration("pollintervalflat", 0,
+			"poll market price interval while there is no position, overrides --pollpriceinterval"),
+		pollIntervalInPosition: flag.Duration("pollintervalinposition", 0,
+			"poll market price interval while there is a position, overrides --pollpriceinterval"),
+		minPollInterval: flag.Duration("minpollinterval", 0,
+			"lower bound of poll interval adapting to price volatility, set with --maxpollinterval to enable it"),
+		maxPollInterval: flag.Duration("maxpollinterval", 0,
+			"upper bound of poll interval adapting to price volatility, set with --minpollinterval to enable it"),
+		noTradeWindows: flag.String("notradewindows", "",
+			"comma separated daily time ranges without trading, example: 22:00-06:00,13:00-14:00 America/New_York"),
+		sizeJitterPercent: flag.String("sizejitterpercent", "0",
+			"randomize every buy amount by up to ±percent around the computed size, for example 5 means ±5%"),
+		minQuoteReserve: flag.String("minquotereserve", "0", "quote balance which is never spent, example: 100"),
+		pairBudget: flag.String("pairbudget", "0",
+			"quote amount the bot treats as its total capital instead of the whole balance, 0 means no budget"),
+		initialBuyNotional: flag.String("initialbuynotional", "0",
+			"exact quote amount of the first buy of every series, 0 means it is derived from --usebalance"),
+		amountRoundDecimals: flag.Int("amountrounddecimals", -1,
+			"number of decimals every buy amount is rounded down to, -1 disables rounding"),
+		reconcileFromExchange: flag.Bool("reconcilefromexchange", false,
+			"take base balance on exchange as the position on start if it doesn't match the tracked one"),
+		maxPriceAge: flag.Duration("maxpriceage", defaultMaxPriceAge,
+			"max age of the price orders are sent at, older price is fetched again before sending, 0 disables the check"),
+		trailingStopPercent: flag.String("trailingstoppercent", "0",
+			"callback percent of exchange-native trailing stop placed after every buy, from 0.1 to 20, 0 disables it"),
+		maxSlippagePercent: flag.String("maxslippagepercent", "0",
+			"max percent best order book price may be off the decided price, orders above it are aborted, 0 disables the check"),
+		clientOrderPrefix: flag.String("clientorderprefix", defaultClientOrderPrefix,
+			"prefix of client order ids making orders of the bot recognizable on exchange, up to 16 characters"),
+		maxHourlyTurnover: flag.String("maxhourlyturnover", "0",
+			"max quote notional traded within an hour, buys beyond it are blocked, 0 means no limit"),
+		maxDailyTurnover: flag.String("maxdailyturnover", "0",
+			"max quote notional traded within a day, buys beyond it are blocked, 0 means no limit"),
+		hangTimeoutMultiplier: flag.Int("hangtimeoutmultiplier", defaultHangTimeoutMultiplier,
+			"number of poll intervals a trade cycle may run before it is reported as hung, 0 disables the watchdog"),
+		maxHangs: flag.Int("maxhangs", defaultMaxHangs, "number of consecutive hangs of trade cycle after which the bot is recreated"),
+	}
+}
+
+func getFromCLI(cli cliFlags) (Config, error) {
+	pair, err := entity.ParsePair(*cli.pair)
 	if err != nil {
-		return entity.Pair{}, 0, decimal.Decimal{}, decimal.Decimal{}, 0, 0, err
+		return Config{}, fmt.Errorf("invalid --pair provided, --pair=%s: %s", *cli.pair, err)
+	}
+	if !validInstanceID(*cli.instanceID) {
+		return Config{}, fmt.Errorf("invalid --instanceid provided, --instanceid=%s", *cli.instanceID)
 	}
-	minChannel, err = decimal.NewFromString(*minch)
+	usebalance, err := decimal.NewFromString(*cli.usebalance)
 	if err != nil {
-		return entity.Pair{}, 0, decimal.Decimal{}, decimal.Decimal{}, 0, 0, err
+		return Config{}, err
+	}
+	minChannel, err := decimal.NewFromString(*cli.minChannel)
+	if err != nil {
+		return Config{}, err
 	}
-
-	hours = *statH
-	rebalanceInterval = *ri
-	pollPriceInterval = *pi
 
 	ub := usebalance.BigInt().Int64()
 
 	if ub < 0 || ub > 100 {
-		return entity.Pair{}, 0, decimal.Decimal{}, decimal.Decimal{}, 0, 0,
-			fmt.Errorf("invalid --usebalance provided, --usebalance=%s", usebalance.String())
+		return Config{}, fmt.Errorf("invalid --usebalance provided, --usebalance=%s", usebalance.String())
 	}
 
-	return pair, hours, usebalance, minChannel, rebalanceInterval, pollPriceInterval, nil
+	sizeJitter, err := parseSizeJitter(*cli.sizeJitterPercent)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid --sizejitterpercent provided, --sizejitterpercent=%s", *cli.sizeJitterPercent)
+	}
+
+	minQuoteReserve, err := parseAmount(*cli.minQuoteReserve)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid --minquotereserve provided, --minquotereserve=%s", *cli.minQuoteReserve)
+	}
+
+	pairBudget, err := parseAmount(*cli.pairBudget)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid --pairbudget provided, --pairbudget=%s", *cli.pairBudget)
+	}
+
+	initialBuyNotional, err := parseAmount(*cli.initialBuyNotional)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid --initialbuynotional provided, --initialbuynotional=%s", *cli.initialBuyNotional)
+	}
+
+	trailingStop, err := parseTrailingStop(*cli.trailingStopPercent)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid --trailingstoppercent provided, --trailingstoppercent=%s", *cli.trailingStopPercent)
+	}
+
+	var amountRoundDecimals *int32
+	if *cli.amountRoundDecimals >= 0 {
+		decimals := int32(*cli.amountRoundDecimals)
+		if !validAmountDecimals(decimals) {
+			return Config{}, fmt.Errorf("invalid --amountrounddecimals provided, --amountrounddecimals=%d", decimals)
+		}
+		amountRoundDecimals = &decimals
+	}
+
+	if !validPollBounds(*cli.minPollInterval, *cli.maxPollInterval) {
+		return Config{}, fmt.Errorf("invalid --minpollinterval and --maxpollinterval provided, --minpollinterval=%s --maxpollinterval=%s",
+			*cli.minPollInterval, *cli.maxPollInterval)
+	}
+
+	maxSlippage, err := parseAmount(*cli.maxSlippagePercent)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid --maxslippagepercent provided, --maxslippagepercent=%s", *cli.maxSlippagePercent)
+	}
+
+	maxHourlyTurnover, err := parseAmount(*cli.maxHourlyTurnover)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid --maxhourlyturnover provided, --maxhourlyturnover=%s", *cli.maxHourlyTurnover)
+	}
+	maxDailyTurnover, err := parseAmount(*cli.maxDailyTurnover)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid --maxdailyturnover provided, --maxdailyturnover=%s", *cli.maxDailyTurnover)
+	}
+
+	if !validClientOrderPrefix(*cli.clientOrderPrefix) {
+		return Config{}, fmt.Errorf("invalid --clientorderprefix provided, --clientorderprefix=%s", *cli.clientOrderPrefix)
+	}
+
+	if *cli.hangTimeoutMultiplier < 0 {
+		return Config{}, fmt.Errorf("invalid --hangtimeoutmultiplier provided, --hangtimeoutmultiplier=%d", *cli.hangTimeoutMultiplier)
+	}
+	if *cli.maxHangs < 1 {
+		return Config{}, fmt.Errorf("invalid --maxhangs provided, --maxhangs=%d", *cli.maxHangs)
+	}
+
+	zeroVolumeHandling, err := entity.ParseZeroVolumeHandling(*cli.zeroVolumeHandling)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid --zerovolumehandling provided, --zerovolumehandling=%s", *cli.zeroVolumeHandling)
+	}
+
+	if !validWalSync(*cli.walSync) {
+		return Config{}, fmt.Errorf("invalid --walsync provided, --walsync=%s", *cli.walSync)
+	}
+	if *cli.walSync != defaultWalSync {
+		return Config{}, fmt.Errorf("--walsync=%s is allowed only in simulations, live trading requires always", *cli.walSync)
+	}
+
+	if !validKlineInterval(*cli.klineInterval) {
+		return Config{}, fmt.Errorf("invalid --klineinterval provided, --klineinterval=%s", *cli.klineInterval)
+	}
+
+	var windows []string
+	if *cli.noTradeWindows != "" {
+		windows = strings.Split(*cli.noTradeWindows, ",")
+	}
+	noTradeWindows, err := parseTimeWindows(windows)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid --notradewindows provided, %s", err)
+	}
+
+	return Config{
+		Pair:                   pair,
+		InstanceID:             *cli.instanceID,
+		StatHours:              *cli.statHours,
+		Usebalance:             usebalance,
+		MinChannel:             minChannel,
+		RebalanceInterval:      *cli.rebalanceInterval,
+		PollPriceInterval:      *cli.pollPriceInterval,
+		KlineInterval:          *cli.klineInterval,
+		NatsURL:                *cli.natsURL,
+		SubjectPrefix:          *cli.subjectPrefix,
+		AllowKlineGaps:         *cli.allowKlineGaps,
+		MinKlines:              *cli.minKlines,
+		ZeroVolumeHandling:     zeroVolumeHandling,
+		WalSync:                *cli.walSync,
+		PollIntervalFlat:       *cli.pollIntervalFlat,
+		PollIntervalInPosition: *cli.pollIntervalInPosition,
+		MinPollInterval:        *cli.minPollInterval,
+		MaxPollInterval:        *cli.maxPollInterval,
+		NoTradeWindows:         noTradeWindows,
+		SizeJitterPercent:      sizeJitter,
+		MinQuoteReserve:        minQuoteReserve,
+		PairBudget:             pairBudget,
+		InitialBuyNotional:     initialBuyNotional,
+		TrailingStopPercent:    trailingStop,
+		MaxSlippagePercent:     maxSlippage,
+		AmountRoundDecimals:    amountRoundDecimals,
+		ReconcileFromExchange:  *cli.reconcileFromExchange,
+		MaxPriceAge:            *cli.maxPriceAge,
+		MaxHourlyTurnover:      maxHourlyTurnover,
+		MaxDailyTurnover:       maxDailyTurnover,
+		ClientOrderPrefix:      *cli.clientOrderPrefix,
+		HangTimeoutMultiplier:  *cli.hangTimeoutMultiplier,
+		MaxHangs:               *cli.maxHangs,
+	}, nil
 }
 
-func getYaml(path string) ([]Config, error) {
+// getYaml reads bot configs from yaml files and concatenates them.
+func getYaml(paths ...string) ([]Config, error) {
+	var configs []Config
+	for _, path := range paths {
+		c, err := readYaml(path)
+		if err != nil {
+			return nil, fmt.Errorf("config %s: %w", path, err)
+		}
+		configs = append(configs, c...)
+	}
+
+	if err := checkDuplicates(configs); err != nil {
+		return nil, err
+	}
+
+	return configs, nil
+}
+
+func readYaml(path string) ([]Config, error) {
 	var configsTmp []ConfigTmp
 
 	f, err := os.ReadFile(path)
@@ -107,9 +579,12 @@ func getYaml(path string) ([]Config, error) {
 	configs := make([]Config, 0, len(configsTmp))
 
 	for _, c := range configsTmp {
-		pair, err := getPairFromString(c.Pair)
+		pair, err := entity.ParsePair(c.Pair)
 		if err != nil {
-			return nil, fmt.Errorf("incorrect 'pair' param in yaml config (correct format is COIN1_COIN2), error: %s", err)
+			return nil, fmt.Errorf("incorrect 'pair' param in yaml config (correct format is COIN1_COIN2 or COIN1COIN2), error: %s", err)
+		}
+		if !validInstanceID(c.InstanceID) {
+			return nil, fmt.Errorf("incorrect 'instance_id' param in yaml config (letters, digits and underscores are allowed), got %s", c.InstanceID)
 		}
 		usebalance, err := decimal.NewFromString(c.Usebalance)
 		if err != nil {
@@ -119,23 +594,289 @@ func getYaml(path string) ([]Config, error) {
 		if err != nil {
 			return nil, fmt.Errorf("incorrect 'minChannel' param in yaml config (correct format is 123), error: %s", err)
 		}
+		sizeJitter, err := parseSizeJitter(c.SizeJitterPercent)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect 'size_jitter_percent' param in yaml config (correct format is 5), error: %s", err)
+		}
+		minQuoteReserve, err := parseAmount(c.MinQuoteReserve)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect 'min_quote_reserve' param in yaml config (correct format is 100), error: %s", err)
+		}
+		pairBudget, err := parseAmount(c.PairBudget)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect 'pair_budget' param in yaml config (correct format is 1000), error: %s", err)
+		}
+		initialBuyNotional, err := parseAmount(c.InitialBuyNotional)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect 'initial_buy_notional' param in yaml config (correct format is 50), error: %s", err)
+		}
+		trailingStop, err := parseTrailingStop(c.TrailingStopPercent)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect 'trailing_stop_percent' param in yaml config (correct format is 1.5), error: %s", err)
+		}
+		if c.AmountRoundDecimals != nil && !validAmountDecimals(*c.AmountRoundDecimals) {
+			return nil, fmt.Errorf("incorrect 'amount_round_decimals' param in yaml config (correct format is 4), got %d", *c.AmountRoundDecimals)
+		}
+		maxPriceAge := defaultMaxPriceAge
+		if c.MaxPriceAge != nil {
+			maxPriceAge = *c.MaxPriceAge
+		}
+		if !validPollBounds(c.MinPollInterval, c.MaxPollInterval) {
+			return nil, fmt.Errorf("incorrect 'min_poll_interval' and 'max_poll_interval' params in yaml config (both must be set, min not above max), got %s and %s",
+				c.MinPollInterval, c.MaxPollInterval)
+		}
+		maxSlippage, err := parseAmount(c.MaxSlippagePercent)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect 'max_slippage_percent' param in yaml config (correct format is 0.5), error: %s", err)
+		}
+		maxHourlyTurnover, err := parseAmount(c.MaxHourlyTurnover)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect 'max_hourly_turnover' param in yaml config (correct format is 1000), error: %s", err)
+		}
+		maxDailyTurnover, err := parseAmount(c.MaxDailyTurnover)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect 'max_daily_turnover' param in yaml config (correct format is 5000), error: %s", err)
+		}
+		clientOrderPrefix := defaultClientOrderPrefix
+		if c.ClientOrderPrefix != "" {
+			clientOrderPrefix = c.ClientOrderPrefix
+		}
+		if !validClientOrderPrefix(clientOrderPrefix) {
+			return nil, fmt.Errorf("incorrect 'client_order_prefix' param in yaml config (up to %d letters, digits and ._:/- characters), got %s",
+				maxClientOrderPrefixLen, clientOrderPrefix)
+		}
+		allowKlineGaps := true
+		if c.AllowKlineGaps != nil {
+			allowKlineGaps = *c.AllowKlineGaps
+		}
+		subjectPrefix := defaultSubjectPrefix
+		if c.SubjectPrefix != nil {
+			subjectPrefix = *c.SubjectPrefix
+		}
+		hangTimeoutMultiplier := defaultHangTimeoutMultiplier
+		if c.HangTimeoutMultiplier != nil {
+			hangTimeoutMultiplier = *c.HangTimeoutMultiplier
+		}
+		if hangTimeoutMultiplier < 0 {
+			return nil, fmt.Errorf("incorrect 'hang_timeout_multiplier' param in yaml config (correct format is 5), got %d", hangTimeoutMultiplier)
+		}
+		maxHangs := defaultMaxHangs
+		if c.MaxHangs != nil {
+			maxHangs = *c.MaxHangs
+		}
+		if maxHangs < 1 {
+			return nil, fmt.Errorf("incorrect 'max_hangs' param in yaml config (correct format is 3), got %d", maxHangs)
+		}
+		zeroVolumeHandling, err := entity.ParseZeroVolumeHandling(c.ZeroVolumeHandling)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect 'zero_volume_handling' param in yaml config, error: %s", err)
+		}
+		walSync := defaultWalSync
+		if c.WalSync != "" {
+			walSync = c.WalSync
+		}
+		if !validWalSync(walSync) {
+			return nil, fmt.Errorf("incorrect 'wal_sync' param in yaml config (always, interval or never), got %s", walSync)
+		}
+		if walSync != defaultWalSync {
+			return nil, fmt.Errorf("'wal_sync' %s is allowed only in simulations, live trading requires always", walSync)
+		}
+		if !validKlineInterval(c.KlineInterval) {
+			return nil, fmt.Errorf("incorrect 'klineinterval' param in yaml config (correct format is 4h), got %s", c.KlineInterval)
+		}
+		noTradeWindows, err := parseTimeWindows(c.NoTradeWindows)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect 'no_trade_windows' param in yaml config (correct format is 22:00-06:00 Europe/London), error: %s", err)
+		}
 
 		configs = append(configs, Config{
-			Pair:              pair,
-			StatHours:         c.StatHours,
-			Usebalance:        usebalance,
-			MinChannel:        minChannel,
-			RebalanceInterval: c.RebalanceInterval,
-			PollPriceInterval: c.PollPriceInterval,
+			Pair:                   pair,
+			InstanceID:             c.InstanceID,
+			StatHours:              c.StatHours,
+			Usebalance:             usebalance,
+			MinChannel:             minChannel,
+			RebalanceInterval:      c.RebalanceInterval,
+			PollPriceInterval:      c.PollPriceInterval,
+			KlineInterval:          c.KlineInterval,
+			NatsURL:                c.NatsURL,
+			SubjectPrefix:          subjectPrefix,
+			AllowKlineGaps:         allowKlineGaps,
+			MinKlines:              c.MinKlines,
+			ZeroVolumeHandling:     zeroVolumeHandling,
+			WalSync:                walSync,
+			PollIntervalFlat:       c.PollIntervalFlat,
+			PollIntervalInPosition: c.PollIntervalInPosition,
+			MinPollInterval:        c.MinPollInterval,
+			MaxPollInterval:        c.MaxPollInterval,
+			NoTradeWindows:         noTradeWindows,
+			SizeJitterPercent:      sizeJitter,
+			MinQuoteReserve:        minQuoteReserve,
+			PairBudget:             pairBudget,
+			InitialBuyNotional:     initialBuyNotional,
+			TrailingStopPercent:    trailingStop,
+			MaxSlippagePercent:     maxSlippage,
+			AmountRoundDecimals:    c.AmountRoundDecimals,
+			ReconcileFromExchange:  c.ReconcileFromExchange,
+			MaxPriceAge:            maxPriceAge,
+			MaxHourlyTurnover:      maxHourlyTurnover,
+			MaxDailyTurnover:       maxDailyTurnover,
+			ClientOrderPrefix:      clientOrderPrefix,
+			HangTimeoutMultiplier:  hangTimeoutMultiplier,
+			MaxHangs:               maxHangs,
 		})
 	}
+
 	return configs, nil
 }
 
-func getPairFromString(pairStr string) (entity.Pair, error) {
-	pairElements := strings.Split(pairStr, "_")
-	if len(pairElements) != 2 {
-		return entity.Pair{}, fmt.Errorf("invalid pair param")
+// checkDuplicates rejects configs that run several bots for the same pair without distinct instance ids,
+// such bots would share state and race to buy the same asset.
+func checkDuplicates(configs []Config) error {
+	seen := make(map[string]int, len(configs))
+	var duplicates []string
+	for _, c := range configs {
+		bot := entity.BotID(c.Pair, c.InstanceID)
+		seen[bot]++
+		if seen[bot] == 2 {
+			duplicates = append(duplicates, bot)
+		}
+	}
+
+	if len(duplicates) > 0 {
+		return fmt.Errorf("duplicate pairs in config: %s", strings.Join(duplicates, ", "))
+	}
+
+	return nil
+}
+
+// parseSizeJitter parses size jitter percent, empty value means no jitter.
+func parseSizeJitter(s string) (decimal.Decimal, error) {
+	if s == "" {
+		return decimal.Zero, nil
+	}
+
+	jitter, err := decimal.NewFromString(s)
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+	if jitter.IsNegative() || jitter.GreaterThanOrEqual(decimal.NewFromInt(100)) {
+		return decimal.Decimal{}, fmt.Errorf("size jitter must be in range [0, 100), got %s", s)
+	}
+
+	return jitter, nil
+}
+
+// parseAmount parses optional non-negative amount like quote reserve or budget, empty value means zero.
+func parseAmount(s string) (decimal.Decimal, error) {
+	if s == "" {
+		return decimal.Zero, nil
+	}
+
+	amount, err := decimal.NewFromString(s)
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+	if amount.IsNegative() {
+		return decimal.Decimal{}, fmt.Errorf("amount must not be negative, got %s", s)
+	}
+
+	return amount, nil
+}
+
+// parseTrailingStop parses trailing stop callback percent, empty value means no trailing stop.
+// Binance accepts trailing delta from 10 to 2000 BIPS, that is from 0.1% to 20%.
+func parseTrailingStop(s string) (decimal.Decimal, error) {
+	if s == "" {
+		return decimal.Zero, nil
 	}
-	return entity.Pair{From: pairElements[0], To: pairElements[1]}, nil
+
+	percent, err := decimal.NewFromString(s)
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+	if percent.IsZero() {
+		return percent, nil
+	}
+	if percent.LessThan(decimal.RequireFromString("0.1")) || percent.GreaterThan(decimal.NewFromInt(20)) {
+		return decimal.Decimal{}, fmt.Errorf("trailing stop percent must be 0 or in range [0.1, 20], got %s", s)
+	}
+
+	return percent, nil
+}
+
+// validAmountDecimals checks number of decimals amounts are rounded to, 18 is the precision of stored amounts.
+func validAmountDecimals(decimals int32) bool {
+	return decimals >= 0 && decimals <= 18
+}
+
+// validPollBounds checks bounds of adaptive poll interval, both bounds are zero if it is disabled.
+func validPollBounds(minInterval, maxInterval time.Duration) bool {
+	if minInterval == 0 && maxInterval == 0 {
+		return true
+	}
+
+	return minInterval > 0 && minInterval <= maxInterval
+}
+
+// validClientOrderPrefix checks that prefix consists of characters accepted in client order ids by exchange.
+func validClientOrderPrefix(prefix string) bool {
+	if len(prefix) > maxClientOrderPrefixLen {
+		return false
+	}
+	for _, r := range prefix {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("._:/-", r)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validInstanceID checks that instance id is safe to be used in file names, empty id is valid.
+func validInstanceID(id string) bool {
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validWalSync checks that WAL sync mode is known.
+func validWalSync(mode string) bool {
+	switch mode {
+	case "always", "interval", "never":
+		return true
+	default:
+		return false
+	}
+}
+
+// validKlineInterval checks that kline interval is a whole number of minutes, zero means default interval.
+func validKlineInterval(interval time.Duration) bool {
+	return interval >= 0 && interval%time.Minute == 0
+}
+
+// validOtelEndpoint checks that OTLP endpoint is http or https url, empty endpoint is valid.
+func validOtelEndpoint(endpoint string) bool {
+	if endpoint == "" {
+		return true
+	}
+	u, err := url.Parse(endpoint)
+
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func parseTimeWindows(windows []string) ([]entity.TimeWindow, error) {
+	res := make([]entity.TimeWindow, 0, len(windows))
+	for _, w := range windows {
+		window, err := entity.ParseTimeWindow(w)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, window)
+	}
+
+	return res, nil
 }
@@ -0,0 +1,303 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"github.com/vadiminshakov/marti/entity"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	return path
+}
+
+func TestGetYaml(t *testing.T) {
+	path := writeConfig(t, `
+- pair: BTC_USDT
+  usebalance: 38
+  minchannel: 100
+  stathours: 120
+  rebalanceinterval: 16h
+  pollpriceinterval: 5m
+- pair: ETH_USDT
+  usebalance: 27
+  minchannel: 7
+  stathours: 240
+  rebalanceinterval: 30h
+  pollpriceinterval: 5m
+`)
+
+	configs, err := getYaml(path)
+	require.NoError(t, err)
+	require.Len(t, configs, 2)
+	require.Equal(t, "BTC_USDT", configs[0].Pair.String())
+	require.Equal(t, "ETH_USDT", configs[1].Pair.String())
+}
+
+func TestGetYamlDuplicatePairs(t *testing.T) {
+	path := writeConfig(t, `
+- pair: BTC_USDT
+  usebalance: 38
+  minchannel: 100
+- pair: ETH_USDT
+  usebalance: 27
+  minchannel: 7
+- pair: BTC_USDT
+  usebalance: 10
+  minchannel: 100
+`)
+
+	_, err := getYaml(path)
+	require.ErrorContains(t, err, "duplicate pairs in config: BTC_USDT")
+}
+
+func TestGetYamlInstanceID(t *testing.T) {
+	path := writeConfig(t, `
+- pair: BTC_USDT
+  instance_id: fast
+  usebalance: 38
+  minchannel: 100
+- pair: BTC_USDT
+  instance_id: slow
+  usebalance: 10
+  minchannel: 100
+`)
+
+	configs, err := getYaml(path)
+	require.NoError(t, err)
+	require.Equal(t, "fast", configs[0].InstanceID)
+	require.Equal(t, "slow", configs[1].InstanceID)
+
+	path = writeConfig(t, `
+- pair: BTC_USDT
+  instance_id: ../fast
+  usebalance: 38
+  minchannel: 100
+`)
+	_, err = getYaml(path)
+	require.ErrorContains(t, err, "instance_id")
+}
+
+func TestGetYamlSubjectPrefix(t *testing.T) {
+	path := writeConfig(t, `
+- pair: BTC_USDT
+  usebalance: 38
+  minchannel: 100
+- pair: ETH_USDT
+  usebalance: 38
+  minchannel: 100
+  subject_prefix: ""
+`)
+
+	configs, err := getYaml(path)
+	require.NoError(t, err)
+	require.Equal(t, "marti", configs[0].SubjectPrefix, "default must match the CLI one")
+	require.Empty(t, configs[1].SubjectPrefix)
+}
+
+func TestGetYamlAllowKlineGaps(t *testing.T) {
+	path := writeConfig(t, `
+- pair: BTC_USDT
+  usebalance: 38
+  minchannel: 100
+- pair: ETH_USDT
+  usebalance: 38
+  minchannel: 100
+  allowklinegaps: false
+`)
+
+	configs, err := getYaml(path)
+	require.NoError(t, err)
+	require.True(t, configs[0].AllowKlineGaps, "gaps must be allowed in degraded mode by default")
+	require.False(t, configs[1].AllowKlineGaps)
+}
+
+func TestGetYamlWalSync(t *testing.T) {
+	path := writeConfig(t, `
+- pair: BTC_USDT
+  usebalance: 38
+  minchannel: 100
+- pair: ETH_USDT
+  usebalance: 38
+  minchannel: 100
+  wal_sync: interval
+`)
+
+	// relaxed modes may lose fills of live trading on crash
+	_, err := getYaml(path)
+	require.ErrorContains(t, err, "live trading requires always")
+
+	path = writeConfig(t, `
+- pair: BTC_USDT
+  usebalance: 38
+  minchannel: 100
+- pair: ETH_USDT
+  usebalance: 38
+  minchannel: 100
+  wal_sync: always
+`)
+	configs, err := getYaml(path)
+	require.NoError(t, err)
+	require.Equal(t, "always", configs[0].WalSync)
+	require.Equal(t, "always", configs[1].WalSync)
+
+	path = writeConfig(t, `
+- pair: BTC_USDT
+  usebalance: 38
+  minchannel: 100
+  wal_sync: sometimes
+`)
+	_, err = getYaml(path)
+	require.ErrorContains(t, err, "wal_sync")
+}
+
+func TestGetYamlClientOrderPrefix(t *testing.T) {
+	path := writeConfig(t, `
+- pair: BTC_USDT
+  usebalance: 38
+  minchannel: 100
+- pair: ETH_USDT
+  client_order_prefix: dca-eth-
+  usebalance: 27
+  minchannel: 7
+`)
+
+	configs, err := getYaml(path)
+	require.NoError(t, err)
+	require.Equal(t, "marti-", configs[0].ClientOrderPrefix)
+	require.Equal(t, "dca-eth-", configs[1].ClientOrderPrefix)
+
+	path = writeConfig(t, `
+- pair: BTC_USDT
+  client_order_prefix: marti order
+  usebalance: 38
+  minchannel: 100
+`)
+	_, err = getYaml(path)
+	require.ErrorContains(t, err, "client_order_prefix")
+}
+
+func TestGetYamlNoTradeWindows(t *testing.T) {
+	path := writeConfig(t, `
+- pair: BTC_USDT
+  usebalance: 38
+  minchannel: 100
+  no_trade_windows:
+    - 22:00-06:00
+    - 08:00-09:00 America/New_York
+`)
+
+	configs, err := getYaml(path)
+	require.NoError(t, err)
+	require.Len(t, configs[0].NoTradeWindows, 2)
+	require.Equal(t, "22:00-06:00 UTC", configs[0].NoTradeWindows[0].String())
+	require.Equal(t, "08:00-09:00 America/New_York", configs[0].NoTradeWindows[1].String())
+
+	path = writeConfig(t, `
+- pair: BTC_USDT
+  usebalance: 38
+  minchannel: 100
+  no_trade_windows:
+    - 22:00
+`)
+
+	_, err = getYaml(path)
+	require.ErrorContains(t, err, "no_trade_windows")
+}
+
+func TestGetYamlMultipleFiles(t *testing.T) {
+	dca := writeConfig(t, `
+- pair: BTC_USDT
+  usebalance: 38
+  minchannel: 100
+- pair: ETH_USDT
+  usebalance: 27
+  minchannel: 7
+`)
+	alts := writeConfig(t, `
+- pair: SOL_USDT
+  usebalance: 10
+  minchannel: 1
+`)
+
+	configs, err := getYaml(dca, alts)
+	require.NoError(t, err)
+	require.Len(t, configs, 3)
+	require.Equal(t, "SOL_USDT", configs[2].Pair.String())
+
+	duplicate := writeConfig(t, `
+- pair: ETH_USDT
+  usebalance: 10
+  minchannel: 7
+`)
+
+	_, err = getYaml(dca, alts, duplicate)
+	require.ErrorContains(t, err, "duplicate pairs in config: ETH_USDT")
+}
+
+func TestGetYamlAmountRoundDecimals(t *testing.T) {
+	path := writeConfig(t, `
+- pair: BTC_USDT
+  usebalance: 38
+  minchannel: 100
+  amount_round_decimals: 0
+- pair: ETH_USDT
+  usebalance: 27
+  minchannel: 7
+`)
+
+	configs, err := getYaml(path)
+	require.NoError(t, err)
+	require.NotNil(t, configs[0].AmountRoundDecimals)
+	require.Equal(t, int32(0), *configs[0].AmountRoundDecimals)
+	require.Nil(t, configs[1].AmountRoundDecimals, "rounding is disabled if not set")
+
+	path = writeConfig(t, `
+- pair: BTC_USDT
+  usebalance: 38
+  minchannel: 100
+  amount_round_decimals: -1
+`)
+
+	_, err = getYaml(path)
+	require.ErrorContains(t, err, "amount_round_decimals")
+}
+
+func TestGetYamlMaxPriceAge(t *testing.T) {
+	path := writeConfig(t, `
+- pair: BTC_USDT
+  usebalance: 38
+  minchannel: 100
+- pair: ETH_USDT
+  usebalance: 27
+  minchannel: 7
+  max_price_age: 5s
+`)
+
+	configs, err := getYaml(path)
+	require.NoError(t, err)
+	require.Zero(t, configs[0].MaxPriceAge, "the check is disabled by default")
+	require.Equal(t, 5*time.Second, configs[1].MaxPriceAge)
+}
+
+func TestCheckDailyLossQuote(t *testing.T) {
+	btc := Config{Pair: entity.Pair{From: "BTC", To: "USDT"}}
+	eth := Config{Pair: entity.Pair{From: "ETH", To: "USDT"}}
+	ethBTC := Config{Pair: entity.Pair{From: "ETH", To: "BTC"}}
+
+	require.NoError(t, checkDailyLossQuote(Global{}, []Config{btc, ethBTC}))
+
+	limited := Global{MaxDailyLoss: decimal.NewFromInt(100)}
+	require.NoError(t, checkDailyLossQuote(limited, []Config{btc, eth}))
+	require.ErrorContains(t, checkDailyLossQuote(limited, []Config{btc, eth, ethBTC}), "same currency")
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/vadiminshakov/marti/entity"
+)
+
+// cycleLimiter bounds the number of trade cycles running at once across all bots,
+// cycles of the other bots wait for a free slot.
+type cycleLimiter struct {
+	slots chan struct{}
+}
+
+func newCycleLimiter(maxCycles int) *cycleLimiter {
+	return &cycleLimiter{slots: make(chan struct{}, maxCycles)}
+}
+
+// wrap returns trade cycle which runs only when a slot is free. Nil limiter doesn't limit cycles.
+func (l *cycleLimiter) wrap(trade func() (*entity.TradeEvent, error)) func() (*entity.TradeEvent, error) {
+	if l == nil {
+		return trade
+	}
+
+	return func() (*entity.TradeEvent, error) {
+		slot := l.slot()
+		slot.acquire()
+		defer slot.release()
+
+		return trade()
+	}
+}
+
+// slot returns slot for one trade cycle. Slot of nil limiter is never waited for.
+func (l *cycleLimiter) slot() *cycleSlot {
+	return &cycleSlot{limiter: l}
+}
+
+// cycleSlot is the slot of one trade cycle, it can be released before the cycle completes, e.g. when
+// the cycle hangs, so it doesn't block cycles of the other bots.
+type cycleSlot struct {
+	limiter *cycleLimiter
+	mu      sync.Mutex
+	held    bool
+}
+
+// acquire waits for a free slot.
+func (s *cycleSlot) acquire() {
+	if s.limiter == nil {
+		return
+	}
+
+	s.limiter.slots <- struct{}{}
+	s.mu.Lock()
+	s.held = true
+	s.mu.Unlock()
+}
+
+// release frees the slot if it is held, it is safe to call several times and concurrently with acquire.
+func (s *cycleSlot) release() {
+	if s.limiter == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.held {
+		<-s.limiter.slots
+		s.held = false
+	}
+}
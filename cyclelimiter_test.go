@@ -0,0 +1,54 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vadiminshakov/marti/entity"
+)
+
+func TestCycleLimiter(t *testing.T) {
+	const maxCycles = 3
+	limiter := newCycleLimiter(maxCycles)
+
+	var running, maxRunning, completed atomic.Int32
+	trade := limiter.wrap(func() (*entity.TradeEvent, error) {
+		n := running.Add(1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+		completed.Add(1)
+		return nil, nil
+	})
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := trade()
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	require.EqualValues(t, 20, completed.Load(), "queued cycles must run once slots are free")
+	require.EqualValues(t, maxCycles, maxRunning.Load())
+}
+
+func TestCycleLimiterNil(t *testing.T) {
+	var limiter *cycleLimiter
+	te, err := limiter.wrap(func() (*entity.TradeEvent, error) {
+		return &entity.TradeEvent{Action: entity.ActionSell}, nil
+	})()
+	require.NoError(t, err)
+	require.Equal(t, entity.ActionSell, te.Action)
+}
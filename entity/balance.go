@@ -0,0 +1,16 @@
+package entity
+
+import "github.com/shopspring/decimal"
+
+// Balance is balance of a currency on exchange.
+type Balance struct {
+	// Free is the amount available for new orders.
+	Free decimal.Decimal
+	// Locked is the amount locked in open orders.
+	Locked decimal.Decimal
+}
+
+// Total returns free and locked amounts together.
+func (b Balance) Total() decimal.Decimal {
+	return b.Free.Add(b.Locked)
+}
@@ -1,6 +1,35 @@
 package entity
 
-import "github.com/shopspring/decimal"
+import (
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// ZeroVolumeHandling defines how klines without trades, common for illiquid pairs, are used in calculations.
+type ZeroVolumeHandling string
+
+const (
+	// ZeroVolumeKeep uses zero-volume klines as is.
+	ZeroVolumeKeep ZeroVolumeHandling = "keep"
+	// ZeroVolumeSkip drops zero-volume klines.
+	ZeroVolumeSkip ZeroVolumeHandling = "skip"
+	// ZeroVolumeFill replaces prices of zero-volume klines with close price of the previous kline.
+	ZeroVolumeFill ZeroVolumeHandling = "fill"
+)
+
+// ParseZeroVolumeHandling parses handling of zero-volume klines, empty string means ZeroVolumeKeep.
+func ParseZeroVolumeHandling(s string) (ZeroVolumeHandling, error) {
+	switch h := ZeroVolumeHandling(s); h {
+	case "":
+		return ZeroVolumeKeep, nil
+	case ZeroVolumeKeep, ZeroVolumeSkip, ZeroVolumeFill:
+		return h, nil
+	default:
+		return "", fmt.Errorf("invalid zero volume handling %q, expected keep, skip or fill", s)
+	}
+}
 
 type Kliner interface {
 	OpenPrice() decimal.Decimal
@@ -8,8 +37,12 @@ type Kliner interface {
 }
 
 type Kline struct {
-	Open  decimal.Decimal
-	Close decimal.Decimal
+	OpenTime time.Time
+	Open     decimal.Decimal
+	High     decimal.Decimal
+	Low      decimal.Decimal
+	Close    decimal.Decimal
+	Volume   decimal.Decimal
 }
 
 func (k *Kline) OpenPrice() decimal.Decimal {
@@ -1,6 +1,29 @@
 package entity
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
+
+// Supported trading platforms.
+const (
+	PlatformBinance = "binance"
+	PlatformBybit   = "bybit"
+	PlatformKraken  = "kraken"
+)
+
+// pairSeparators are separators accepted between pair assets, the canonical one is underscore.
+const pairSeparators = "_/-"
+
+// quoteAssets are assets known to be quoted against, used to split symbols without separator, e.g. BTCUSDT.
+// Longer assets are matched first, so that ETHBUSD is split as ETH/BUSD rather than ETHB/USD.
+var quoteAssets = []string{"FDUSD", "USDT", "USDC", "BUSD", "TUSD", "USD", "EUR", "GBP", "TRY", "BRL", "BTC", "ETH", "BNB"}
+
+// krakenAssets maps canonical asset names to the ones used by Kraken.
+var krakenAssets = map[string]string{
+	"BTC":  "XBT",
+	"DOGE": "XDG",
+}
 
 type Pair struct {
 	From string
@@ -11,6 +34,55 @@ func (p *Pair) String() string {
 	return fmt.Sprintf("%s_%s", p.From, p.To)
 }
 
+// ParsePair parses pair written as BTC_USDT, BTC/USDT, BTC-USDT or BTCUSDT, case insensitive.
+// Symbols without separator are split by a known quote asset suffix.
+func ParsePair(s string) (Pair, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+
+	if i := strings.IndexAny(s, pairSeparators); i >= 0 {
+		from, to := s[:i], s[i+1:]
+		if from == "" || to == "" || strings.ContainsAny(to, pairSeparators) {
+			return Pair{}, fmt.Errorf("invalid pair %q, expected format is BTC_USDT", s)
+		}
+		return Pair{From: from, To: to}, nil
+	}
+
+	for _, quote := range quoteAssets {
+		if from, ok := strings.CutSuffix(s, quote); ok && from != "" {
+			return Pair{From: from, To: quote}, nil
+		}
+	}
+
+	return Pair{}, fmt.Errorf("invalid pair %q, expected format is BTC_USDT (quote asset of %q is unknown)", s, s)
+}
+
+// BotID returns id of the bot trading pair, instanceID distinguishes isolated bots trading the same pair.
+func BotID(pair Pair, instanceID string) string {
+	if instanceID == "" {
+		return pair.String()
+	}
+
+	return pair.String() + "-" + instanceID
+}
+
 func (p *Pair) Symbol() string {
 	return fmt.Sprintf("%s%s", p.From, p.To)
 }
+
+// SymbolFor returns pair symbol in the format of the trading platform, e.g. BTCUSDT for Binance and XBTUSDT for Kraken.
+func (p *Pair) SymbolFor(platform string) string {
+	switch strings.ToLower(platform) {
+	case PlatformKraken:
+		return krakenAsset(p.From) + krakenAsset(p.To)
+	default:
+		return p.Symbol()
+	}
+}
+
+func krakenAsset(asset string) string {
+	if a, ok := krakenAssets[asset]; ok {
+		return a
+	}
+
+	return asset
+}
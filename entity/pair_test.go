@@ -0,0 +1,53 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSymbolFor(t *testing.T) {
+	pair := Pair{From: "BTC", To: "USDT"}
+
+	require.Equal(t, "BTCUSDT", pair.SymbolFor(PlatformBinance))
+	require.Equal(t, "BTCUSDT", pair.SymbolFor(PlatformBybit))
+	require.Equal(t, "XBTUSDT", pair.SymbolFor(PlatformKraken))
+	require.Equal(t, "XBTUSDT", pair.SymbolFor("Kraken"))
+
+	pair = Pair{From: "ETH", To: "USDT"}
+	require.Equal(t, "ETHUSDT", pair.SymbolFor(PlatformKraken))
+}
+
+func TestParsePair(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Pair
+	}{
+		{"BTC_USDT", Pair{From: "BTC", To: "USDT"}},
+		{"BTC/USDT", Pair{From: "BTC", To: "USDT"}},
+		{"BTC-USDT", Pair{From: "BTC", To: "USDT"}},
+		{"btc_usdt", Pair{From: "BTC", To: "USDT"}},
+		{"BTCUSDT", Pair{From: "BTC", To: "USDT"}},
+		{"ethbtc", Pair{From: "ETH", To: "BTC"}},
+		{"ETHBUSD", Pair{From: "ETH", To: "BUSD"}},
+		{"BTCUSD", Pair{From: "BTC", To: "USD"}},
+		{"WBTCBTC", Pair{From: "WBTC", To: "BTC"}},
+		{"BTCFDUSD", Pair{From: "BTC", To: "FDUSD"}},
+		{"USDTTRY", Pair{From: "USDT", To: "TRY"}},
+		{"WBTC_BTC", Pair{From: "WBTC", To: "BTC"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			pair, err := ParsePair(tt.in)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, pair)
+		})
+	}
+
+	for _, in := range []string{"", "BTC", "USDT", "BTC_", "_USDT", "BTC_USDT_ETH", "BTC/USDT-ETH", "BTCXYZ"} {
+		t.Run("invalid "+in, func(t *testing.T) {
+			_, err := ParsePair(in)
+			require.Error(t, err)
+		})
+	}
+}
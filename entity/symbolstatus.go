@@ -0,0 +1,18 @@
+package entity
+
+// SymbolStatus is trading status of a pair on exchange.
+type SymbolStatus string
+
+const (
+	SymbolStatusTrading     SymbolStatus = "TRADING"
+	SymbolStatusPreTrading  SymbolStatus = "PRE_TRADING"
+	SymbolStatusPostTrading SymbolStatus = "POST_TRADING"
+	SymbolStatusEndOfDay    SymbolStatus = "END_OF_DAY"
+	SymbolStatusHalt        SymbolStatus = "HALT"
+	SymbolStatusBreak       SymbolStatus = "BREAK"
+)
+
+// Halted returns true if new orders can't be placed for the pair (trading halt, delisting, etc.).
+func (s SymbolStatus) Halted() bool {
+	return s != SymbolStatusTrading
+}
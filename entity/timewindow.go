@@ -0,0 +1,77 @@
+package entity
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a daily time range in a timezone, e.g. 22:00-06:00 Europe/London.
+// The window spans midnight if it ends before it starts.
+type TimeWindow struct {
+	// From and To are offsets from the start of the day.
+	From     time.Duration
+	To       time.Duration
+	Location *time.Location
+}
+
+// ParseTimeWindow parses window in format "HH:MM-HH:MM [IANA timezone]", timezone is UTC if omitted.
+func ParseTimeWindow(s string) (TimeWindow, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return TimeWindow{}, fmt.Errorf("invalid time window %q, expected format is HH:MM-HH:MM [timezone]", s)
+	}
+
+	bounds := strings.Split(fields[0], "-")
+	if len(bounds) != 2 {
+		return TimeWindow{}, fmt.Errorf("invalid time window %q, expected format is HH:MM-HH:MM [timezone]", s)
+	}
+
+	from, err := parseClock(bounds[0])
+	if err != nil {
+		return TimeWindow{}, fmt.Errorf("invalid start of time window %q: %w", s, err)
+	}
+	to, err := parseClock(bounds[1])
+	if err != nil {
+		return TimeWindow{}, fmt.Errorf("invalid end of time window %q: %w", s, err)
+	}
+	if from == to {
+		return TimeWindow{}, fmt.Errorf("invalid time window %q, start and end are equal", s)
+	}
+
+	loc := time.UTC
+	if len(fields) == 2 {
+		if loc, err = time.LoadLocation(fields[1]); err != nil {
+			return TimeWindow{}, fmt.Errorf("invalid timezone of time window %q: %w", s, err)
+		}
+	}
+
+	return TimeWindow{From: from, To: to, Location: loc}, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains returns true if t falls within the window.
+func (w TimeWindow) Contains(t time.Time) bool {
+	t = t.In(w.Location)
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+
+	if w.From < w.To {
+		return clock >= w.From && clock < w.To
+	}
+
+	return clock >= w.From || clock < w.To
+}
+
+func (w TimeWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d %s", int(w.From.Hours()), int(w.From.Minutes())%60,
+		int(w.To.Hours()), int(w.To.Minutes())%60, w.Location)
+}
@@ -0,0 +1,61 @@
+package entity
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeWindowContains(t *testing.T) {
+	w, err := ParseTimeWindow("13:30-14:15")
+	require.NoError(t, err)
+	require.Equal(t, time.UTC, w.Location)
+
+	day := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)
+	require.False(t, w.Contains(day.Add(13*time.Hour+29*time.Minute)))
+	require.True(t, w.Contains(day.Add(13*time.Hour+30*time.Minute)))
+	require.True(t, w.Contains(day.Add(14*time.Hour+14*time.Minute+59*time.Second)))
+	require.False(t, w.Contains(day.Add(14*time.Hour+15*time.Minute)))
+}
+
+func TestTimeWindowSpansMidnight(t *testing.T) {
+	w, err := ParseTimeWindow("22:00-06:00")
+	require.NoError(t, err)
+
+	day := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)
+	require.True(t, w.Contains(day.Add(23*time.Hour)))
+	require.True(t, w.Contains(day))
+	require.True(t, w.Contains(day.Add(5*time.Hour+59*time.Minute)))
+	require.False(t, w.Contains(day.Add(6*time.Hour)))
+	require.False(t, w.Contains(day.Add(21*time.Hour+59*time.Minute)))
+	require.True(t, w.Contains(day.Add(22*time.Hour)))
+}
+
+func TestTimeWindowTimezone(t *testing.T) {
+	// US CPI release at 8:30 New York time
+	w, err := ParseTimeWindow("08:00-09:00 America/New_York")
+	require.NoError(t, err)
+	require.Equal(t, "08:00-09:00 America/New_York", w.String())
+
+	// EDT, UTC-4
+	require.True(t, w.Contains(time.Date(2024, 7, 10, 12, 30, 0, 0, time.UTC)))
+	require.False(t, w.Contains(time.Date(2024, 7, 10, 8, 30, 0, 0, time.UTC)))
+	// EST, UTC-5
+	require.True(t, w.Contains(time.Date(2024, 1, 10, 13, 30, 0, 0, time.UTC)))
+	require.False(t, w.Contains(time.Date(2024, 1, 10, 12, 30, 0, 0, time.UTC)))
+
+	// window spanning midnight in local time, 23:00-01:00 Tokyo is 14:00-16:00 UTC
+	w, err = ParseTimeWindow("23:00-01:00 Asia/Tokyo")
+	require.NoError(t, err)
+	require.True(t, w.Contains(time.Date(2024, 1, 10, 15, 59, 0, 0, time.UTC)))
+	require.False(t, w.Contains(time.Date(2024, 1, 10, 16, 0, 0, 0, time.UTC)))
+}
+
+func TestParseTimeWindowInvalid(t *testing.T) {
+	for _, s := range []string{"", "22:00", "22:00-25:00", "10:00-10:00", "22:00-06:00 Mars/Olympus", "22:00-06:00 UTC extra"} {
+		_, err := ParseTimeWindow(s)
+		require.Error(t, err, s)
+	}
+}
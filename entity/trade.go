@@ -5,13 +5,37 @@ import (
 	"github.com/shopspring/decimal"
 )
 
+// TradeReason explains why the trade was made.
+type TradeReason string
+
+const (
+	// TradeReasonEntry is the first buy of a position.
+	TradeReasonEntry TradeReason = "entry"
+	// TradeReasonDCA is a buy averaging down an open position.
+	TradeReasonDCA TradeReason = "dca"
+	// TradeReasonTakeProfit is a sell of the whole position in profit.
+	TradeReasonTakeProfit TradeReason = "take_profit"
+	// TradeReasonStopLoss is a sell of the whole position at a loss after all DCA buys are done.
+	TradeReasonStopLoss TradeReason = "stop_loss"
+	// TradeReasonTrailingStop is a sell of the whole position by exchange-side trailing stop.
+	TradeReasonTrailingStop TradeReason = "trailing_stop"
+	// TradeReasonDailyLoss is a sell of the whole position after the daily loss limit of all bots is exceeded.
+	TradeReasonDailyLoss TradeReason = "daily_loss"
+	// TradeReasonFeeTopUp is a buy of BNB paying trading fees, it is not a part of any position.
+	TradeReasonFeeTopUp TradeReason = "fee_topup"
+)
+
 type TradeEvent struct {
 	Action Action
+	Reason TradeReason
 	Pair   Pair
 	Amount decimal.Decimal
 	Price  decimal.Decimal
+	// CorrelationID identifies the trade operation in logs, alerts and published events.
+	CorrelationID string
 }
 
 func (t *TradeEvent) String() string {
-	return fmt.Sprintf("%s action: %s amount: %s", t.Pair.String(), t.Action.String(), t.Amount.String())
+	return fmt.Sprintf("%s action: %s reason: %s amount: %s id: %s", t.Pair.String(), t.Action.String(), t.Reason,
+		t.Amount.String(), t.CorrelationID)
 }
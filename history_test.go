@@ -194,7 +194,7 @@ func createTradeServiceFactory(logger *zap.Logger, pair *entity.Pair, prices cha
 			lastaction: lastAction,
 			buypoint:   buyPrice,
 			window:     window,
-		}, trader, anomDetector)
+		}, trader, anomDetector, nil, services.WalConfig{SyncMode: services.WalSyncNever})
 		if err != nil {
 			return nil, err
 		}
@@ -78,6 +78,15 @@ func (t *traderCsv) Buy(amount decimal.Decimal) error {
 	return nil
 }
 
+// GetBalance returns balance of currency.
+func (t *traderCsv) GetBalance(currency string) (decimal.Decimal, error) {
+	if currency == t.pair.From {
+		return t.balance1, nil
+	}
+
+	return t.balance2, nil
+}
+
 // Sell sells amount of asset in trade pair.
 func (t *traderCsv) Sell(amount decimal.Decimal) error {
 	if t.balance1.LessThanOrEqual(decimal.Zero) {
@@ -5,50 +5,211 @@ import (
 	"fmt"
 	"github.com/hirokisan/bybit/v2"
 	"github.com/vadiminshakov/marti/config"
+	"github.com/vadiminshakov/marti/entity"
+	"github.com/vadiminshakov/marti/secrets"
+	"github.com/vadiminshakov/marti/services"
 	"log"
+	"net/http"
 	"os"
 	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/vadiminshakov/marti/services/bnbfee"
 	"github.com/vadiminshakov/marti/services/channel"
+	"github.com/vadiminshakov/marti/services/logdedup"
+	"github.com/vadiminshakov/marti/services/publisher"
+	"github.com/vadiminshakov/marti/services/ratelimit"
+	"github.com/vadiminshakov/marti/services/symbolstatus"
+	"github.com/vadiminshakov/marti/services/tracing"
+	binancetrader "github.com/vadiminshakov/marti/services/trader"
+	"github.com/vadiminshakov/marti/services/turnover"
 
 	"github.com/adshao/go-binance/v2"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"golang.org/x/sync/errgroup"
 )
 
 const (
 	restartWaitSec = 30
+	// turnoverFile keeps notional traded by all bots, so turnover limits survive restarts.
+	turnoverFile = "waldata/turnover.json"
 
-	platform = "binance"
+	platform = entity.PlatformBinance
 )
 
 func main() {
-	apikey := os.Getenv("APIKEY")
-	if len(apikey) == 0 {
-		log.Fatal("APIKEY env is not set")
+	logger, _ := zap.NewProduction()
+	defer logger.Sync()
+
+	global, configs, err := config.Get()
+	if err != nil {
+		logger.Fatal("failed to get configuration", zap.Error(err))
 	}
 
-	secretKey := os.Getenv("SECRETKEY")
-	if len(apikey) == 0 {
-		log.Fatal("SECRETKEY env is not set")
+	if global.LogDedupWindow > 0 {
+		logger = logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return logdedup.NewCore(core, global.LogDedupWindow)
+		}))
+		defer logger.Sync()
 	}
 
-	logger, _ := zap.NewProduction()
-	defer logger.Sync()
+	if global.ResetPair != nil {
+		if err := resetPair(*global.ResetPair, global.ResetInstanceID, os.Stdin, os.Stdout); err != nil {
+			logger.Fatal("failed to reset pair state", zap.Error(err))
+		}
+		return
+	}
 
-	configs, err := config.Get()
+	var walCfg services.WalConfig
+	if key, ok, err := secrets.Lookup("DATA_ENCRYPTION_KEY"); err != nil {
+		log.Fatal(err)
+	} else if ok {
+		if walCfg.EncryptionKey, err = services.ParseEncryptionKey(key); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if global.ResumeTurnover != nil {
+		if err := resumeTurnover(turnoverFile, *global.ResumeTurnover, walCfg.EncryptionKey, os.Stdout); err != nil {
+			logger.Fatal("failed to resume turnover", zap.Error(err))
+		}
+		return
+	}
+
+	shutdownTracing, err := tracing.Setup(global.OtelEndpoint)
 	if err != nil {
-		logger.Fatal("failed to get configuration", zap.Error(err))
+		logger.Fatal("failed to set up tracing", zap.Error(err))
+	}
+	defer shutdownTracing(context.Background())
+
+	apikey, err := secrets.Get("APIKEY")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	secretKey, err := secrets.Get("SECRETKEY")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	httpClient := &http.Client{}
+	if global.MaxRequestsPerMinute > 0 {
+		limiter := ratelimit.NewLimiter(global.MaxRequestsPerMinute, global.RateLimitFailFast)
+		httpClient.Transport = limiter.Transport(http.DefaultTransport)
 	}
 
 	binanceClient := binance.NewClient(apikey, secretKey)
+	binanceClient.HTTPClient = httpClient
+
+	if global.ValidatePairs && platform == entity.PlatformBinance {
+		for _, conf := range configs {
+			if err := symbolstatus.NewBinanceChecker(binanceClient, conf.Pair).CheckListed(); err != nil {
+				logger.Fatal("invalid pair", zap.Error(err))
+			}
+		}
+	}
+
+	if global.BNBMinBalance.IsPositive() {
+		bnbPair := entity.Pair{From: bnbfee.Asset, To: global.BNBTopUpQuote}
+		bnbTrader, err := binancetrader.NewTrader(binanceClient, bnbPair)
+		if err != nil {
+			logger.Fatal("failed to create BNB trader", zap.Error(err))
+		}
+		monitor := bnbfee.NewMonitor(binanceClient, bnbTrader, global.BNBTopUpQuote, global.BNBMinBalance, global.BNBTopUp)
+		if global.BNBTopUp.IsPositive() {
+			// top-ups are journaled in own WAL, so they never get into positions of the bots
+			topUpWalCfg := walCfg
+			topUpWalCfg.Dir = services.WalDir(bnbPair, bnbTopUpInstance)
+			topUpWal, err := services.NewWrappedWal(topUpWalCfg)
+			if err != nil {
+				logger.Fatal("failed to open BNB top-up WAL", zap.Error(err))
+			}
+			defer topUpWal.Close()
+			monitor.SetJournal(topUpWal)
+		}
+		go watchBNBBalance(logger, monitor, bnbCheckInterval)
+	}
+
+	var exposure *services.ExposureTracker
+	if global.MaxExposurePercent.IsPositive() {
+		capital, err := totalCapital(binanceClient, configs)
+		if err != nil {
+			logger.Fatal("failed to calculate total capital", zap.Error(err))
+		}
+		exposure = services.NewExposureTracker(capital, global.MaxExposurePercent)
+		logger.Info("global exposure limit",
+			zap.String("capital", capital.String()),
+			zap.String("max percent", global.MaxExposurePercent.String()))
+	}
+
+	var dailyLoss *services.DailyLossTracker
+	if global.MaxDailyLoss.IsPositive() {
+		dailyLoss = services.NewDailyLossTracker(global.MaxDailyLoss)
+		logger.Info("daily loss limit", zap.String("max loss", global.MaxDailyLoss.String()))
+	}
+
+	var turnoverTracker *turnover.Tracker
+	if turnoverLimited(global, configs) {
+		turnoverTracker, err = turnover.NewTracker(turnoverFile,
+			turnover.Limits{Hourly: global.MaxHourlyTurnover, Daily: global.MaxDailyTurnover}, walCfg.EncryptionKey)
+		if err != nil {
+			logger.Fatal("failed to load turnover", zap.Error(err))
+		}
+	}
+
+	var limiter *cycleLimiter
+	if global.MaxConcurrentBots > 0 {
+		limiter = newCycleLimiter(global.MaxConcurrentBots)
+	}
+
+	shared := sharedPairs(configs)
 
 	g := new(errgroup.Group)
 	var timerStarted atomic.Bool
 	timerStarted.Store(false)
+	if len(configs) > 1 && services.HasLegacyWal() {
+		// state of several bots can't be told apart, starting them would lose positions of the legacy state
+		logger.Fatal("WAL shared by all pairs is found in waldata, it can't be migrated when several bots are configured. " +
+			"Start once with only the pair the state belongs to, so it is migrated to waldata/<PAIR>, " +
+			"or remove waldata/seg_* files if the state is not needed")
+	}
+
+	// bots publishing to the same server share the connection
+	natsPublishers := make(map[string]*publisher.NatsPublisher)
 	for _, conf := range configs {
+		var pub Publisher
+		if conf.NatsURL != "" {
+			natsPublisher, ok := natsPublishers[conf.NatsURL]
+			if !ok {
+				natsPublisher, err = publisher.NewNatsPublisher(conf.NatsURL, conf.SubjectPrefix)
+				if err != nil {
+					// publishing must never block trading
+					logger.Error("failed to create trade events publisher, events are not published",
+						zap.String("pair", conf.Pair.String()), zap.Error(err))
+				} else {
+					defer natsPublisher.Close()
+				}
+				natsPublishers[conf.NatsURL] = natsPublisher
+			}
+			if natsPublisher != nil {
+				pub = natsPublisher.WithSubjectPrefix(conf.SubjectPrefix)
+			}
+		}
+
+		botWalCfg := walCfg
+		botWalCfg.Dir = services.WalDir(conf.Pair, conf.InstanceID)
+		botWalCfg.SyncMode = services.WalSyncMode(conf.WalSync)
+		if len(configs) == 1 {
+			// state of the single bot is unambiguous, so it can be taken from the WAL shared by all pairs
+			if err := services.MigrateLegacyWal(botWalCfg.Dir); err != nil {
+				logger.Fatal("failed to migrate WAL", zap.String("pair", conf.Pair.String()), zap.Error(err))
+			}
+		}
+
+		// status outlives recreations of the bot
+		status := &services.BotStatus{}
 		g.Go(func() error {
 			for {
 				ctx, cancel := context.WithTimeout(context.Background(), conf.RebalanceInterval)
@@ -56,9 +217,19 @@ func main() {
 
 				executor := func(context.Context) error { return nil }
 
-				if platform == "binance" {
-					cf := channel.NewBinanceChannelFinder(binanceClient, conf.Pair, conf.StatHours)
-					executor, err = binanceTradeServiceCreator(logger, cf, binanceClient, conf.Pair, conf.Usebalance, conf.PollPriceInterval)
+				if platform == entity.PlatformBinance {
+					cf := channel.NewBinanceChannelFinder(binanceClient, conf.Pair, conf.StatHours, conf.KlineInterval,
+						conf.AllowKlineGaps, conf.MinKlines)
+					cf.SetZeroVolumeHandling(conf.ZeroVolumeHandling)
+					executor, err = binanceTradeServiceCreator(logger, cf, binanceClient, conf, shared[conf.Pair.String()], pub,
+						exposure, dailyLoss, turnoverTracker, limiter, status, botWalCfg)
+					if errors.Is(err, channel.ErrNotEnoughKlines) {
+						cancel()
+						logger.Warn(fmt.Sprintf("not enough klines for pair %s, skip for %s", conf.Pair.String(),
+							conf.PollPriceInterval), zap.Error(err))
+						time.Sleep(conf.PollPriceInterval)
+						continue
+					}
 					if err != nil {
 						logger.Error(fmt.Sprintf("failed to create binance trader service for pair %s, recreate instance after %ds", conf.Pair.String(),
 							restartWaitSec*2), zap.Error(err))
@@ -67,10 +238,12 @@ func main() {
 					}
 				}
 
-				if platform == "bybit" {
-					bybitClient := bybit.NewClient().WithAuth(apikey, secretKey)
+				if platform == entity.PlatformBybit {
+					bybitClient := bybit.NewClient().WithAuth(apikey, secretKey).WithHTTPClient(httpClient)
 
-					cf := channel.NewBybitChannelFinder(bybitClient, conf.Pair, conf.StatHours)
+					cf := channel.NewBybitChannelFinder(bybitClient, conf.Pair, conf.StatHours, conf.KlineInterval,
+						conf.AllowKlineGaps, conf.MinKlines)
+					cf.SetZeroVolumeHandling(conf.ZeroVolumeHandling)
 
 					executor = func(context.Context) error {
 						buyprice, channel, err := cf.GetTradingChannel()
@@ -78,7 +251,7 @@ func main() {
 							return errors.Wrapf(err, "failed to find window for %s", conf.Pair.String())
 						}
 
-						fmt.Printf("buyprice: %v, channel: %v\n", buyprice, channel)
+						logger.Info("start", zap.String("buyprice", buyprice.String()), zap.String("channel", channel.String()))
 						select {}
 
 						return nil
@@ -126,3 +299,34 @@ func timer(ctx context.Context, recreateInterval time.Duration, timerStarted *at
 		}
 	}
 }
+
+// turnoverLimited returns true if turnover of any bot or of all of them is limited.
+func turnoverLimited(global config.Global, configs []config.Config) bool {
+	if global.MaxHourlyTurnover.IsPositive() || global.MaxDailyTurnover.IsPositive() {
+		return true
+	}
+	for _, c := range configs {
+		if c.MaxHourlyTurnover.IsPositive() || c.MaxDailyTurnover.IsPositive() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sharedPairs returns pairs traded by several instances.
+func sharedPairs(configs []config.Config) map[string]bool {
+	bots := make(map[string]int, len(configs))
+	for _, c := range configs {
+		bots[c.Pair.String()]++
+	}
+
+	shared := make(map[string]bool)
+	for pair, n := range bots {
+		if n > 1 {
+			shared[pair] = true
+		}
+	}
+
+	return shared
+}
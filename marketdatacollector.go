@@ -3,23 +3,23 @@ package main
 import (
 	"context"
 	"encoding/csv"
-	"errors"
 	"github.com/adshao/go-binance/v2"
 	"github.com/vadiminshakov/marti/entity"
+	"github.com/vadiminshakov/marti/secrets"
 	"os"
 	"sort"
 	"time"
 )
 
 func dataColletorFactory(filePath string, pair *entity.Pair) (func(fromHoursAgo, toHoursAgo int, klinesize string) error, error) {
-	apikey := os.Getenv("APIKEY")
-	if len(apikey) == 0 {
-		return nil, errors.New("APIKEY env is not set")
+	apikey, err := secrets.Get("APIKEY")
+	if err != nil {
+		return nil, err
 	}
 
-	secretkey := os.Getenv("SECRETKEY")
-	if len(apikey) == 0 {
-		return nil, errors.New("SECRETKEY env is not set")
+	secretkey, err := secrets.Get("SECRETKEY")
+	if err != nil {
+		return nil, err
 	}
 
 	client := binance.NewClient(apikey, secretkey)
@@ -37,7 +37,7 @@ func collectMarketData(client *binance.Client, pair *entity.Pair, fromHoursAgo,
 	startTime := time.Now().Add(-time.Duration(fromHoursAgo)*time.Hour).Unix() * 1000
 	endTime := time.Now().Add(-time.Duration(toHoursAgo)*time.Hour).Unix() * 1000
 
-	klines, err := client.NewKlinesService().Symbol(pair.Symbol()).StartTime(startTime).
+	klines, err := client.NewKlinesService().Symbol(pair.SymbolFor(entity.PlatformBinance)).StartTime(startTime).
 		EndTime(endTime).
 		Interval(klinesize).Do(context.Background())
 	if err != nil {
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// volatilityWindow is the number of price changes between polls volatility is measured over.
+const volatilityWindow = 10
+
+var (
+	// highVolatilityPercent is mean price change between polls above which adaptive poll interval is halved.
+	highVolatilityPercent = decimal.NewFromFloat(0.2)
+	// lowVolatilityPercent is mean price change between polls below which adaptive poll interval is doubled.
+	lowVolatilityPercent = decimal.NewFromFloat(0.05)
+)
+
+// pollIntervals selects how often the bot polls market price depending on whether it holds a position.
+type pollIntervals struct {
+	base       time.Duration
+	flat       time.Duration
+	inPosition time.Duration
+	// adaptive overrides all the intervals if set
+	adaptive *adaptivePoll
+}
+
+// next returns poll interval for the position state, falls back to the base interval if no override is set.
+func (p pollIntervals) next(inPosition bool) time.Duration {
+	if p.adaptive != nil {
+		return p.adaptive.interval
+	}
+	if inPosition && p.inPosition > 0 {
+		return p.inPosition
+	}
+	if !inPosition && p.flat > 0 {
+		return p.flat
+	}
+
+	return p.base
+}
+
+// adaptivePoll follows price volatility: poll interval is shortened in fast markets not to lag behind the price
+// and lengthened in calm ones not to waste requests, within [min, max].
+type adaptivePoll struct {
+	min      time.Duration
+	max      time.Duration
+	interval time.Duration
+
+	last decimal.Decimal
+	// changes are absolute price changes between polls in percent, measured at the current interval
+	changes []decimal.Decimal
+}
+
+func newAdaptivePoll(minInterval, maxInterval, initial time.Duration) *adaptivePoll {
+	return &adaptivePoll{min: minInterval, max: maxInterval, interval: min(max(initial, minInterval), maxInterval)}
+}
+
+// observe records polled price. Once volatility window is filled, the interval is adjusted
+// and the window is started over to measure volatility at the new interval.
+func (a *adaptivePoll) observe(price decimal.Decimal) {
+	if !price.IsPositive() {
+		return
+	}
+	if a.last.IsPositive() {
+		a.changes = append(a.changes, price.Sub(a.last).Abs().Div(a.last).Mul(decimal.NewFromInt(100)))
+	}
+	a.last = price
+	if len(a.changes) < volatilityWindow {
+		return
+	}
+
+	volatility := decimal.Sum(decimal.Zero, a.changes...).Div(decimal.NewFromInt(int64(len(a.changes))))
+	switch {
+	case volatility.GreaterThan(highVolatilityPercent):
+		a.interval = max(a.interval/2, a.min)
+	case volatility.LessThan(lowVolatilityPercent):
+		a.interval = min(a.interval*2, a.max)
+	}
+	a.changes = a.changes[:0]
+}
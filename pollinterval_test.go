@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/vadiminshakov/marti/entity"
+	"github.com/vadiminshakov/marti/services"
+	"github.com/vadiminshakov/marti/services/anomalydetector"
+	tradermock "github.com/vadiminshakov/marti/services/trader/mock"
+	"go.uber.org/zap"
+)
+
+func TestPollIntervals(t *testing.T) {
+	intervals := pollIntervals{base: 5 * time.Minute, flat: 10 * time.Minute, inPosition: time.Minute}
+	require.Equal(t, 10*time.Minute, intervals.next(false))
+	require.Equal(t, time.Minute, intervals.next(true))
+
+	intervals = pollIntervals{base: 5 * time.Minute}
+	require.Equal(t, 5*time.Minute, intervals.next(false))
+	require.Equal(t, 5*time.Minute, intervals.next(true))
+}
+
+func TestPollIntervalsFollowPosition(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	pair := entity.Pair{From: "BTC", To: "USDT"}
+	prices := make(chan decimal.Decimal, 2)
+	trader := tradermock.NewTrader(t)
+	trader.On("Buy", mock.Anything).Return(nil)
+	trader.On("Sell", mock.Anything).Return(nil)
+	trader.On("GetBalance", "USDT").Return(decimal.NewFromInt(1000), nil)
+
+	ts, err := services.NewTradeService(zap.NewNop(), pair, decimal.NewFromInt(1), &pricerCsv{pricesCh: prices},
+		&detectorCsv{lastaction: entity.ActionSell, buypoint: decimal.NewFromInt(100), window: decimal.NewFromInt(10)},
+		trader, anomalydetector.NewAnomalyDetector(pair, 30, decimal.NewFromInt(10)), nil, services.WalConfig{})
+	require.NoError(t, err)
+	defer ts.Close()
+
+	intervals := pollIntervals{base: 5 * time.Minute, flat: 10 * time.Minute, inPosition: time.Minute}
+	require.Equal(t, 10*time.Minute, intervals.next(ts.InPosition()))
+
+	// price drops below the channel, position is opened
+	prices <- decimal.NewFromInt(94)
+	te, err := ts.Trade()
+	require.NoError(t, err)
+	require.Equal(t, entity.ActionBuy, te.Action)
+	require.Equal(t, time.Minute, intervals.next(ts.InPosition()))
+
+	// price rises above the channel, position is closed
+	prices <- decimal.NewFromInt(106)
+	te, err = ts.Trade()
+	require.NoError(t, err)
+	require.Equal(t, entity.ActionSell, te.Action)
+	require.Equal(t, 10*time.Minute, intervals.next(ts.InPosition()))
+}
+
+func TestAdaptivePollFollowsVolatility(t *testing.T) {
+	adaptive := newAdaptivePoll(time.Minute, 8*time.Minute, 4*time.Minute)
+	intervals := pollIntervals{base: 4 * time.Minute, inPosition: time.Hour, adaptive: adaptive}
+	require.Equal(t, 4*time.Minute, intervals.next(true), "adaptive interval overrides the others")
+
+	// feed prices alternating by the given percent move
+	feed := func(movePercent float64) {
+		price := decimal.NewFromInt(100)
+		move := decimal.NewFromFloat(movePercent)
+		for i := 0; i <= volatilityWindow; i++ {
+			if i%2 == 1 {
+				adaptive.observe(price.Add(move))
+			} else {
+				adaptive.observe(price)
+			}
+		}
+	}
+
+	// fast market, interval is halved down to the lower bound
+	feed(1)
+	require.Equal(t, 2*time.Minute, intervals.next(false))
+	feed(1)
+	require.Equal(t, time.Minute, intervals.next(false))
+	feed(1)
+	require.Equal(t, time.Minute, intervals.next(false))
+
+	// moderate market, interval is kept
+	feed(0.1)
+	require.Equal(t, time.Minute, intervals.next(false))
+
+	// calm market, interval is doubled up to the upper bound
+	for _, want := range []time.Duration{2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 8 * time.Minute} {
+		feed(0.01)
+		require.Equal(t, want, intervals.next(false))
+	}
+
+	// initial interval is kept within bounds
+	require.Equal(t, 8*time.Minute, newAdaptivePoll(time.Minute, 8*time.Minute, time.Hour).interval)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/vadiminshakov/marti/entity"
+	"github.com/vadiminshakov/marti/services"
+	"github.com/vadiminshakov/marti/services/turnover"
+)
+
+// resetPair removes stored state of the bot trading pair after the pair name is typed in as confirmation.
+// It refuses to remove the state used by a running bot, and refuses while the WAL shared by all pairs
+// is not migrated, since the next start of a single bot would migrate the old state back.
+func resetPair(pair entity.Pair, instanceID string, in io.Reader, out io.Writer) error {
+	if services.HasLegacyWal() {
+		return errors.New("WAL shared by all pairs is found in waldata, its state may belong to the pair. " +
+			"Start once with only the pair the state belongs to, so it is migrated to waldata/<PAIR>, " +
+			"or remove waldata/seg_* files if the state is not needed")
+	}
+
+	bot := entity.BotID(pair, instanceID)
+	fmt.Fprintf(out, "all stored state of bot %s will be removed, type the pair to confirm: ", bot)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return errors.Wrap(err, "failed to read confirmation")
+	}
+	if strings.TrimSpace(answer) != pair.String() {
+		return errors.New("reset is not confirmed")
+	}
+
+	if err := services.ResetWal(services.WalDir(pair, instanceID)); err != nil {
+		return errors.Wrapf(err, "failed to reset state of bot %s", bot)
+	}
+	fmt.Fprintf(out, "state of bot %s is removed\n", bot)
+
+	return nil
+}
+
+// resumeTurnover makes turnover traded so far by the bot (or by all bots) not count towards turnover limits,
+// so buys blocked by the limits are resumed. Running bots keep their own turnover, so they must be stopped first.
+func resumeTurnover(path, bot string, key []byte, out io.Writer) error {
+	tracker, err := turnover.NewTracker(path, turnover.Limits{}, key)
+	if err != nil {
+		return err
+	}
+	if err := tracker.Resume(bot); err != nil {
+		return errors.Wrapf(err, "failed to resume turnover of %s", bot)
+	}
+	fmt.Fprintf(out, "turnover of %s is resumed\n", bot)
+
+	return nil
+}
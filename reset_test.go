@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"github.com/vadiminshakov/marti/entity"
+	"github.com/vadiminshakov/marti/services"
+)
+
+func TestResetPair(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	pair := entity.Pair{From: "BTC", To: "USDT"}
+	w, err := services.NewWrappedWal(services.WalConfig{Dir: services.WalDir(pair, "")})
+	require.NoError(t, err)
+	require.NoError(t, w.Write("lastbuy", decimal.NewFromInt(100)))
+
+	require.ErrorIs(t, resetPair(pair, "", strings.NewReader("BTC_USDT\n"), io.Discard), services.ErrWalLocked)
+	require.NoError(t, w.Close())
+
+	require.Error(t, resetPair(pair, "", strings.NewReader("y\n"), io.Discard), "reset must be confirmed with the pair")
+	require.DirExists(t, services.WalDir(pair, ""))
+
+	require.NoError(t, resetPair(pair, "", strings.NewReader("BTC_USDT\n"), io.Discard))
+	require.NoDirExists(t, services.WalDir(pair, ""))
+}
+
+func TestResetPairWithLegacyWal(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	w, err := services.NewWrappedWal(services.WalConfig{})
+	require.NoError(t, err)
+	require.NoError(t, w.Write("lastbuy", decimal.NewFromInt(100)))
+	require.NoError(t, w.Close())
+
+	// the legacy state would be migrated back to the pair on the next start of a single bot
+	pair := entity.Pair{From: "BTC", To: "USDT"}
+	require.ErrorContains(t, resetPair(pair, "", strings.NewReader("BTC_USDT\n"), io.Discard), "shared by all pairs")
+	require.True(t, services.HasLegacyWal())
+
+	require.NoError(t, services.MigrateLegacyWal(services.WalDir(pair, "")))
+	require.NoError(t, resetPair(pair, "", strings.NewReader("BTC_USDT\n"), io.Discard))
+	require.False(t, services.HasLegacyWal())
+	require.NoDirExists(t, services.WalDir(pair, ""))
+}
+
+func TestResumeTurnover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "turnover.json")
+	require.NoError(t, resumeTurnover(path, "BTC_USDT", nil, io.Discard))
+	require.FileExists(t, path)
+}
@@ -0,0 +1,109 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	fileRefPrefix = "file:"
+	envRefPrefix  = "env:"
+	fileEnvSuffix = "_FILE"
+)
+
+// Resolver resolves secrets from an external store (Vault, SOPS, etc.).
+// Resolve returns false if the store does not know the secret.
+type Resolver interface {
+	Resolve(name string) (string, bool, error)
+}
+
+var resolvers []Resolver
+
+// RegisterResolver adds external secret store consulted when the secret is not found in the environment.
+func RegisterResolver(r Resolver) {
+	resolvers = append(resolvers, r)
+}
+
+// Get resolves secret by name. The lookup order is:
+//   - NAME env variable, its value may reference another source as file:./path or env:OTHER_NAME;
+//   - NAME_FILE env variable with path to the file containing the secret (Docker/K8s secrets convention);
+//   - registered resolvers.
+//
+// Returned errors name the secret but never contain its value.
+func Get(name string) (string, error) {
+	secret, ok, err := Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("secret %s is not set (set %s or %s%s env)", name, name, name, fileEnvSuffix)
+	}
+
+	return secret, nil
+}
+
+// Lookup resolves optional secret by name in the same order as Get, returns false if the secret is not set.
+func Lookup(name string) (string, bool, error) {
+	if v, ok := os.LookupEnv(name); ok && v != "" {
+		secret, err := ResolveRef(v)
+		if err != nil {
+			return "", false, errors.Wrapf(err, "failed to resolve secret %s", name)
+		}
+		return secret, true, nil
+	}
+
+	if path, ok := os.LookupEnv(name + fileEnvSuffix); ok && path != "" {
+		secret, err := readFile(path)
+		if err != nil {
+			return "", false, errors.Wrapf(err, "failed to resolve secret %s from %s%s", name, name, fileEnvSuffix)
+		}
+		return secret, true, nil
+	}
+
+	for _, r := range resolvers {
+		secret, ok, err := r.Resolve(name)
+		if err != nil {
+			return "", false, errors.Wrapf(err, "failed to resolve secret %s", name)
+		}
+		if ok {
+			return secret, true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+// ResolveRef resolves value referencing a secret source: file:./path reads the file,
+// env:NAME reads the env variable, any other value is returned as is.
+func ResolveRef(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, fileRefPrefix):
+		return readFile(strings.TrimPrefix(value, fileRefPrefix))
+	case strings.HasPrefix(value, envRefPrefix):
+		name := strings.TrimPrefix(value, envRefPrefix)
+		v := os.Getenv(name)
+		if v == "" {
+			return "", fmt.Errorf("referenced env %s is not set", name)
+		}
+		return v, nil
+	default:
+		return value, nil
+	}
+}
+
+func readFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read secret file")
+	}
+
+	secret := strings.TrimSpace(string(b))
+	if secret == "" {
+		return "", fmt.Errorf("secret file %s is empty", path)
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,108 @@
+package secrets
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type resolvermock struct {
+	secrets map[string]string
+	err     error
+}
+
+func (r *resolvermock) Resolve(name string) (string, bool, error) {
+	if r.err != nil {
+		return "", false, r.err
+	}
+	s, ok := r.secrets[name]
+	return s, ok, nil
+}
+
+func writeSecret(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	return path
+}
+
+func TestGetFromEnv(t *testing.T) {
+	t.Setenv("MARTI_TEST_KEY", "envsecret")
+	t.Setenv("MARTI_TEST_KEY_FILE", writeSecret(t, "filesecret"))
+
+	secret, err := Get("MARTI_TEST_KEY")
+	require.NoError(t, err)
+	require.Equal(t, "envsecret", secret, "env value must take precedence over _FILE")
+}
+
+func TestGetFromFileEnv(t *testing.T) {
+	t.Setenv("MARTI_TEST_KEY_FILE", writeSecret(t, "filesecret\n"))
+
+	secret, err := Get("MARTI_TEST_KEY")
+	require.NoError(t, err)
+	require.Equal(t, "filesecret", secret)
+}
+
+func TestGetReferences(t *testing.T) {
+	t.Setenv("MARTI_TEST_KEY", "file:"+writeSecret(t, "filesecret"))
+	secret, err := Get("MARTI_TEST_KEY")
+	require.NoError(t, err)
+	require.Equal(t, "filesecret", secret)
+
+	t.Setenv("MARTI_OTHER_KEY", "othersecret")
+	t.Setenv("MARTI_TEST_KEY", "env:MARTI_OTHER_KEY")
+	secret, err = Get("MARTI_TEST_KEY")
+	require.NoError(t, err)
+	require.Equal(t, "othersecret", secret)
+}
+
+func TestGetFromResolver(t *testing.T) {
+	defer func(r []Resolver) { resolvers = r }(resolvers)
+	RegisterResolver(&resolvermock{secrets: map[string]string{"MARTI_TEST_KEY": "vaultsecret"}})
+
+	secret, err := Get("MARTI_TEST_KEY")
+	require.NoError(t, err)
+	require.Equal(t, "vaultsecret", secret)
+}
+
+func TestGetErrors(t *testing.T) {
+	_, err := Get("MARTI_TEST_KEY")
+	require.ErrorContains(t, err, "secret MARTI_TEST_KEY is not set")
+
+	t.Setenv("MARTI_TEST_KEY_FILE", filepath.Join(t.TempDir(), "missing"))
+	_, err = Get("MARTI_TEST_KEY")
+	require.ErrorContains(t, err, "MARTI_TEST_KEY_FILE")
+
+	t.Setenv("MARTI_TEST_KEY", "file:"+writeSecret(t, "  "))
+	_, err = Get("MARTI_TEST_KEY")
+	require.ErrorContains(t, err, "failed to resolve secret MARTI_TEST_KEY")
+
+	t.Setenv("MARTI_TEST_KEY", "env:MARTI_MISSING_KEY")
+	_, err = Get("MARTI_TEST_KEY")
+	require.ErrorContains(t, err, "referenced env MARTI_MISSING_KEY is not set")
+}
+
+func TestGetResolverError(t *testing.T) {
+	defer func(r []Resolver) { resolvers = r }(resolvers)
+	RegisterResolver(&resolvermock{err: errors.New("vault is sealed")})
+
+	_, err := Get("MARTI_TEST_KEY")
+	require.ErrorContains(t, err, "vault is sealed")
+}
+
+func TestLookup(t *testing.T) {
+	_, ok, err := Lookup("MARTI_TEST_KEY")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	t.Setenv("MARTI_TEST_KEY", "secret")
+	secret, ok, err := Lookup("MARTI_TEST_KEY")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "secret", secret)
+}
@@ -0,0 +1,127 @@
+package bnbfee
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"github.com/vadiminshakov/marti/entity"
+)
+
+const (
+	// Asset is the asset Binance fees are paid in with discount.
+	Asset = "BNB"
+	// JournalKeyIntent is the journal key of top-up amount written before the buy is sent.
+	JournalKeyIntent = "feetopup_intent"
+	// JournalKeyDone is the journal key of top-up amount written after the buy is done.
+	JournalKeyDone = "feetopup"
+)
+
+// Trader buys BNB for quote currency.
+type Trader interface {
+	// Buy buys amount of BNB.
+	Buy(amount decimal.Decimal) error
+	// GetBalance returns free balance of currency.
+	GetBalance(currency string) (decimal.Decimal, error)
+}
+
+// Journal persists top-ups, so a top-up sent right before crash is not lost.
+type Journal interface {
+	Write(key string, data decimal.Decimal) error
+}
+
+// Status is the result of BNB balance check.
+type Status struct {
+	// Balance is free BNB balance before top-up.
+	Balance decimal.Decimal
+	// Low is true if balance is below the min balance.
+	Low bool
+	// ToppedUp is the amount of BNB bought to top up the balance.
+	ToppedUp decimal.Decimal
+	// Event is the top-up trade tagged with fee top-up reason, nil if nothing is bought.
+	Event *entity.TradeEvent
+}
+
+// Monitor watches BNB balance which pays trading fees with discount, so fees are not silently taken
+// from the traded assets when it runs out.
+// Top-ups are bought by own trader and journaled apart from the bots, so they never become a part of the bots positions.
+type Monitor struct {
+	client     *binance.Client
+	trader     Trader
+	quote      string
+	minBalance decimal.Decimal
+	topUp      decimal.Decimal
+	journal    Journal
+}
+
+// NewMonitor creates monitor warning when BNB balance is below minBalance. If topUp is positive,
+// topUp amount of BNB is bought for quote currency when balance is low.
+func NewMonitor(client *binance.Client, trader Trader, quote string, minBalance, topUp decimal.Decimal) *Monitor {
+	return &Monitor{client: client, trader: trader, quote: quote, minBalance: minBalance, topUp: topUp}
+}
+
+// SetJournal enables journaling of top-ups.
+func (m *Monitor) SetJournal(journal Journal) {
+	m.journal = journal
+}
+
+// FeesInBNB reports whether paying spot trading fees in BNB is enabled for the account.
+func (m *Monitor) FeesInBNB() (bool, error) {
+	res, err := m.client.NewGetBNBBurnService().Do(context.Background())
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get BNB burn status")
+	}
+
+	return res.SpotBNBBurn, nil
+}
+
+// Check checks BNB balance and tops it up if it is low and top-up is enabled.
+func (m *Monitor) Check() (Status, error) {
+	balance, err := m.trader.GetBalance(Asset)
+	if err != nil {
+		return Status{}, errors.Wrap(err, "failed to get BNB balance")
+	}
+
+	status := Status{Balance: balance, Low: balance.LessThan(m.minBalance)}
+	if !status.Low || !m.topUp.IsPositive() {
+		return status, nil
+	}
+
+	if err := m.write(JournalKeyIntent); err != nil {
+		return status, err
+	}
+	if err := m.trader.Buy(m.topUp); err != nil {
+		return status, errors.Wrapf(err, "failed to top up BNB balance by %s", m.topUp.String())
+	}
+	status.ToppedUp = m.topUp
+	status.Event = &entity.TradeEvent{
+		Action:        entity.ActionBuy,
+		Reason:        entity.TradeReasonFeeTopUp,
+		Pair:          entity.Pair{From: Asset, To: m.quote},
+		Amount:        m.topUp,
+		CorrelationID: newCorrelationID(),
+	}
+	if err := m.write(JournalKeyDone); err != nil {
+		return status, err
+	}
+
+	return status, nil
+}
+
+// write journals top-up amount under key if journal is set.
+func (m *Monitor) write(key string) error {
+	if m.journal == nil {
+		return nil
+	}
+
+	return errors.Wrapf(m.journal.Write(key, m.topUp), "failed to journal BNB top-up of %s", m.topUp.String())
+}
+
+func newCorrelationID() string {
+	b := make([]byte, 8)
+	_, _ = crand.Read(b)
+	return hex.EncodeToString(b)
+}
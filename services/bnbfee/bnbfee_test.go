@@ -0,0 +1,143 @@
+package bnbfee
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"github.com/vadiminshakov/marti/entity"
+	"github.com/vadiminshakov/marti/services"
+	"go.uber.org/zap"
+)
+
+type fakeTrader struct {
+	balance decimal.Decimal
+	bought  []decimal.Decimal
+	buyErr  error
+}
+
+func (t *fakeTrader) Buy(amount decimal.Decimal) error {
+	if t.buyErr != nil {
+		return t.buyErr
+	}
+	t.bought = append(t.bought, amount)
+	return nil
+}
+
+func (t *fakeTrader) GetBalance(currency string) (decimal.Decimal, error) {
+	if currency != Asset {
+		return decimal.Zero, errors.New("unexpected currency " + currency)
+	}
+	return t.balance, nil
+}
+
+type journalRecord struct {
+	key  string
+	data decimal.Decimal
+}
+
+type fakeJournal struct {
+	records []journalRecord
+}
+
+func (j *fakeJournal) Write(key string, data decimal.Decimal) error {
+	j.records = append(j.records, journalRecord{key: key, data: data})
+	return nil
+}
+
+func TestFeesInBNB(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/sapi/v1/bnbBurn", r.URL.Path)
+		_, _ = w.Write([]byte(`{"spotBNBBurn":true,"interestBNBBurn":false}`))
+	}))
+	defer srv.Close()
+
+	client := binance.NewClient("key", "secret")
+	client.BaseURL = srv.URL
+
+	enabled, err := NewMonitor(client, &fakeTrader{}, "USDT", decimal.NewFromInt(1), decimal.Zero).FeesInBNB()
+	require.NoError(t, err)
+	require.True(t, enabled)
+}
+
+func TestCheck(t *testing.T) {
+	minBalance := decimal.RequireFromString("0.1")
+
+	trader := &fakeTrader{balance: decimal.RequireFromString("0.5")}
+	status, err := NewMonitor(nil, trader, "USDT", minBalance, decimal.RequireFromString("0.2")).Check()
+	require.NoError(t, err)
+	require.False(t, status.Low)
+	require.Empty(t, trader.bought)
+
+	// low balance is only reported if top-up is disabled
+	trader = &fakeTrader{balance: decimal.RequireFromString("0.05")}
+	status, err = NewMonitor(nil, trader, "USDT", minBalance, decimal.Zero).Check()
+	require.NoError(t, err)
+	require.True(t, status.Low)
+	require.True(t, status.ToppedUp.IsZero())
+	require.Empty(t, trader.bought)
+
+	status, err = NewMonitor(nil, trader, "USDT", minBalance, decimal.RequireFromString("0.2")).Check()
+	require.NoError(t, err)
+	require.True(t, status.Low)
+	require.Equal(t, "0.2", status.ToppedUp.String())
+	require.Equal(t, []decimal.Decimal{decimal.RequireFromString("0.2")}, trader.bought)
+
+	trader = &fakeTrader{balance: decimal.Zero, buyErr: errors.New("insufficient balance")}
+	status, err = NewMonitor(nil, trader, "USDT", minBalance, decimal.RequireFromString("0.2")).Check()
+	require.ErrorContains(t, err, "failed to top up BNB balance by 0.2")
+	require.True(t, status.Low)
+}
+
+func TestCheckJournalsTopUp(t *testing.T) {
+	topUp := decimal.RequireFromString("0.2")
+	journal := &fakeJournal{}
+	monitor := NewMonitor(nil, &fakeTrader{balance: decimal.Zero}, "USDT", decimal.RequireFromString("0.1"), topUp)
+	monitor.SetJournal(journal)
+
+	status, err := monitor.Check()
+	require.NoError(t, err)
+	require.Equal(t, []journalRecord{{JournalKeyIntent, topUp}, {JournalKeyDone, topUp}}, journal.records)
+	require.NotNil(t, status.Event)
+	require.Equal(t, entity.ActionBuy, status.Event.Action)
+	require.Equal(t, entity.TradeReasonFeeTopUp, status.Event.Reason)
+	require.Equal(t, entity.Pair{From: Asset, To: "USDT"}, status.Event.Pair)
+	require.Equal(t, "0.2", status.Event.Amount.String())
+	require.NotEmpty(t, status.Event.CorrelationID)
+
+	// failed buy leaves only the intent
+	journal = &fakeJournal{}
+	monitor = NewMonitor(nil, &fakeTrader{buyErr: errors.New("insufficient balance")}, "USDT",
+		decimal.RequireFromString("0.1"), topUp)
+	monitor.SetJournal(journal)
+	status, err = monitor.Check()
+	require.Error(t, err)
+	require.Nil(t, status.Event)
+	require.Equal(t, []journalRecord{{JournalKeyIntent, topUp}}, journal.records)
+}
+
+func TestTopUpIsNotDCAPurchase(t *testing.T) {
+	pair := entity.Pair{From: Asset, To: "USDT"}
+	walCfg := services.WalConfig{Dir: t.TempDir()}
+	wal, err := services.NewWrappedWal(walCfg)
+	require.NoError(t, err)
+
+	monitor := NewMonitor(nil, &fakeTrader{balance: decimal.Zero}, "USDT", decimal.RequireFromString("0.1"),
+		decimal.RequireFromString("0.2"))
+	monitor.SetJournal(wal)
+	status, err := monitor.Check()
+	require.NoError(t, err)
+	require.NotEqual(t, entity.TradeReasonEntry, status.Event.Reason)
+	require.NotEqual(t, entity.TradeReasonDCA, status.Event.Reason)
+	require.NoError(t, wal.Close())
+
+	// even a bot restored from the top-up journal has no position
+	ts, err := services.NewTradeService(zap.NewNop(), pair, decimal.NewFromInt(1), nil, nil, nil, nil, nil, walCfg)
+	require.NoError(t, err)
+	defer ts.Close()
+	require.True(t, ts.Position().IsZero())
+}
@@ -9,45 +9,105 @@ import (
 	"time"
 )
 
-const klinesize = "4h"
+// klineInterval is the default interval of klines the trading channel is calculated over.
+const klineInterval = 4 * time.Hour
 
 type BinanceWindowFinder struct {
 	client    *binance.Client
 	pair      entity.Pair
 	statHours uint64
+	interval  time.Duration
+	allowGaps bool
+	minKlines int
+	degraded  bool
+	// zeroVolume defines how klines without trades are used, they are kept as is by default
+	zeroVolume entity.ZeroVolumeHandling
 }
 
-func NewBinanceChannelFinder(client *binance.Client, pair entity.Pair, statHours uint64) *BinanceWindowFinder {
-	return &BinanceWindowFinder{client: client, pair: pair, statHours: statHours}
+// NewBinanceChannelFinder creates channel finder for binance exchange. If allowGaps is true, klines with gaps
+// which can't be backfilled are used for calculation and the finder is marked as degraded, otherwise an error is returned.
+// If there are less than minKlines klines (e.g. for new listings), ErrNotEnoughKlines is returned.
+// Klines of the interval (4h if zero) are aggregated from smaller ones if exchange doesn't provide it.
+func NewBinanceChannelFinder(client *binance.Client, pair entity.Pair, statHours uint64, interval time.Duration,
+	allowGaps bool, minKlines int) *BinanceWindowFinder {
+	return &BinanceWindowFinder{client: client, pair: pair, statHours: statHours, interval: interval,
+		allowGaps: allowGaps, minKlines: minKlines}
 }
 
 func (b *BinanceWindowFinder) GetTradingChannel() (decimal.Decimal, decimal.Decimal, error) {
-	startTime := time.Now().Add(-time.Duration(b.statHours)*time.Hour).Unix() * 1000
-	endTime := time.Now().Unix() * 1000
+	interval := b.interval
+	if interval == 0 {
+		interval = klineInterval
+	}
+	base, err := resolveInterval(entity.PlatformBinance, interval)
+	if err != nil {
+		return decimal.Decimal{}, decimal.Decimal{}, errors.Wrapf(err, "pair %s", b.pair.String())
+	}
 
-	klines, err := b.client.NewKlinesService().Symbol(b.pair.Symbol()).StartTime(startTime).
-		EndTime(endTime).
-		Interval(klinesize).Do(context.Background())
+	now := time.Now()
+	klines, gaps, err := fetchContinuousKlines(aggregatingFetcher(b.fetchKlines, base, interval),
+		now.Add(-time.Duration(b.statHours)*time.Hour), now, interval)
 	if err != nil {
 		return decimal.Decimal{}, decimal.Decimal{}, err
 	}
 
+	// too short history of new listings is reported before gaps, so the bot waits for more klines
+	klines = handleZeroVolume(klines, b.zeroVolume)
+	if err := checkKlinesCount(klines, b.minKlines); err != nil {
+		return decimal.Decimal{}, decimal.Decimal{}, errors.Wrapf(err, "pair %s", b.pair.String())
+	}
+
+	b.degraded = len(gaps) > 0
+	if b.degraded && !b.allowGaps {
+		return decimal.Decimal{}, decimal.Decimal{}, errors.Wrapf(ErrKlineGaps, "%d gaps for %s, first missing kline at %s",
+			len(gaps), b.pair.String(), gaps[0].From)
+	}
+
+	buyprice, window, err := CalcBuyPriceAndChannel(klines)
+	return buyprice, window, err
+}
+
+// SetZeroVolumeHandling sets how klines without trades are used for channel calculation.
+func (b *BinanceWindowFinder) SetZeroVolumeHandling(handling entity.ZeroVolumeHandling) {
+	b.zeroVolume = handling
+}
+
+// Degraded returns true if the last channel was calculated over klines with gaps.
+func (b *BinanceWindowFinder) Degraded() bool {
+	return b.degraded
+}
+
+func (b *BinanceWindowFinder) fetchKlines(start, end time.Time, interval nativeInterval) ([]*entity.Kline, error) {
+	klines, err := b.client.NewKlinesService().Symbol(b.pair.SymbolFor(entity.PlatformBinance)).StartTime(start.UnixMilli()).
+		EndTime(end.UnixMilli()).
+		Interval(interval.name).Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
 	klinesconv, err := convertBinanceKlines(klines)
 	if err != nil {
-		return decimal.Decimal{}, decimal.Decimal{}, errors.Wrap(err, "error converting Binance klines")
+		return nil, errors.Wrap(err, "error converting Binance klines")
 	}
-	buyprice, window, err := CalcBuyPriceAndChannel(klinesconv)
-	return buyprice, window, err
+
+	return klinesconv, nil
 }
 
 func convertBinanceKlines(klines []*binance.Kline) ([]*entity.Kline, error) {
 	var res []*entity.Kline
 	for _, k := range klines {
 		openPrice, _ := decimal.NewFromString(k.Open)
+		highPrice, _ := decimal.NewFromString(k.High)
+		lowPrice, _ := decimal.NewFromString(k.Low)
 		closePrice, _ := decimal.NewFromString(k.Close)
+		volume, _ := decimal.NewFromString(k.Volume)
 		res = append(res, &entity.Kline{
-			Open:  openPrice,
-			Close: closePrice,
+			OpenTime: time.UnixMilli(k.OpenTime),
+			Open:     openPrice,
+			High:     highPrice,
+			Low:      lowPrice,
+			Close:    closePrice,
+			Volume:   volume,
 		})
 	}
 	return res, nil
@@ -5,6 +5,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/shopspring/decimal"
 	"github.com/vadiminshakov/marti/entity"
+	"strconv"
 	"time"
 )
 
@@ -12,44 +13,108 @@ type BybitWindowFinder struct {
 	client    *bybit.Client
 	pair      entity.Pair
 	statHours uint64
+	interval  time.Duration
+	allowGaps bool
+	minKlines int
+	degraded  bool
+	// zeroVolume defines how klines without trades are used, they are kept as is by default
+	zeroVolume entity.ZeroVolumeHandling
 }
 
-func NewBybitChannelFinder(client *bybit.Client, pair entity.Pair, statHours uint64) *BybitWindowFinder {
-	return &BybitWindowFinder{client: client, pair: pair, statHours: statHours}
+// NewBybitChannelFinder creates channel finder for bybit exchange. If allowGaps is true, klines with gaps
+// which can't be backfilled are used for calculation and the finder is marked as degraded, otherwise an error is returned.
+// If there are less than minKlines klines (e.g. for new listings), ErrNotEnoughKlines is returned.
+// Klines of the interval (4h if zero) are aggregated from smaller ones if exchange doesn't provide it.
+func NewBybitChannelFinder(client *bybit.Client, pair entity.Pair, statHours uint64, interval time.Duration,
+	allowGaps bool, minKlines int) *BybitWindowFinder {
+	return &BybitWindowFinder{client: client, pair: pair, statHours: statHours, interval: interval,
+		allowGaps: allowGaps, minKlines: minKlines}
 }
 
 func (b *BybitWindowFinder) GetTradingChannel() (decimal.Decimal, decimal.Decimal, error) {
-	startTime := time.Now().Add(-time.Duration(b.statHours)*time.Hour).Unix() * 1000
-	endTime := time.Now().Unix() * 1000
+	interval := b.interval
+	if interval == 0 {
+		interval = klineInterval
+	}
+	base, err := resolveInterval(entity.PlatformBybit, interval)
+	if err != nil {
+		return decimal.Decimal{}, decimal.Decimal{}, errors.Wrapf(err, "pair %s", b.pair.String())
+	}
+
+	now := time.Now()
+	klines, gaps, err := fetchContinuousKlines(aggregatingFetcher(b.fetchKlines, base, interval),
+		now.Add(-time.Duration(b.statHours)*time.Hour), now, interval)
+	if err != nil {
+		return decimal.Decimal{}, decimal.Decimal{}, err
+	}
+
+	// too short history of new listings is reported before gaps, so the bot waits for more klines
+	klines = handleZeroVolume(klines, b.zeroVolume)
+	if err := checkKlinesCount(klines, b.minKlines); err != nil {
+		return decimal.Decimal{}, decimal.Decimal{}, errors.Wrapf(err, "pair %s", b.pair.String())
+	}
+
+	b.degraded = len(gaps) > 0
+	if b.degraded && !b.allowGaps {
+		return decimal.Decimal{}, decimal.Decimal{}, errors.Wrapf(ErrKlineGaps, "%d gaps for %s, first missing kline at %s",
+			len(gaps), b.pair.String(), gaps[0].From)
+	}
 
+	buyprice, window, err := CalcBuyPriceAndChannel(klines)
+	return buyprice, window, err
+}
+
+// SetZeroVolumeHandling sets how klines without trades are used for channel calculation.
+func (b *BybitWindowFinder) SetZeroVolumeHandling(handling entity.ZeroVolumeHandling) {
+	b.zeroVolume = handling
+}
+
+// Degraded returns true if the last channel was calculated over klines with gaps.
+func (b *BybitWindowFinder) Degraded() bool {
+	return b.degraded
+}
+
+func (b *BybitWindowFinder) fetchKlines(start, end time.Time, interval nativeInterval) ([]*entity.Kline, error) {
+	startTime, endTime := start.UnixMilli(), end.UnixMilli()
 	klines, err := b.client.V5().Market().GetKline(bybit.V5GetKlineParam{
 		Category: "spot",
-		Symbol:   bybit.SymbolV5(b.pair.Symbol()),
-		Interval: bybit.Interval240,
+		Symbol:   bybit.SymbolV5(b.pair.SymbolFor(entity.PlatformBybit)),
+		Interval: bybit.Interval(interval.name),
 		Start:    &startTime,
 		End:      &endTime,
 		Limit:    nil,
 	})
 	if err != nil {
-		return decimal.Decimal{}, decimal.Decimal{}, err
+		return nil, err
 	}
 
 	klinesconv, err := convertBybitKlines(klines.Result.List)
 	if err != nil {
-		return decimal.Decimal{}, decimal.Decimal{}, errors.Wrap(err, "error converting Binance klines")
+		return nil, errors.Wrap(err, "error converting Bybit klines")
 	}
-	buyprice, window, err := CalcBuyPriceAndChannel(klinesconv)
-	return buyprice, window, err
+
+	return klinesconv, nil
 }
 
 func convertBybitKlines(klines bybit.V5GetKlineList) ([]*entity.Kline, error) {
 	var res []*entity.Kline
 	for _, k := range klines {
+		startTime, err := strconv.ParseInt(k.StartTime, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid kline start time %s", k.StartTime)
+		}
 		openPrice, _ := decimal.NewFromString(k.Open)
+		highPrice, _ := decimal.NewFromString(k.High)
+		lowPrice, _ := decimal.NewFromString(k.Low)
 		closePrice, _ := decimal.NewFromString(k.Close)
+		volume, _ := decimal.NewFromString(k.Volume)
 		res = append(res, &entity.Kline{
-			Open:  openPrice,
-			Close: closePrice,
+			OpenTime: time.UnixMilli(startTime),
+			Open:     openPrice,
+			High:     highPrice,
+			Low:      lowPrice,
+			Close:    closePrice,
+			Volume:   volume,
 		})
 	}
 	return res, nil
@@ -6,4 +6,6 @@ import (
 
 type ChannelFinder interface {
 	GetTradingChannel() (decimal.Decimal, decimal.Decimal, error)
+	// Degraded returns true if the last channel was calculated over klines with gaps.
+	Degraded() bool
 }
@@ -0,0 +1,123 @@
+package channel
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/vadiminshakov/marti/entity"
+)
+
+// nativeInterval is a kline interval provided by exchange, name is the exchange API representation.
+type nativeInterval struct {
+	interval time.Duration
+	name     string
+}
+
+// nativeIntervals are kline intervals supported by exchanges, sorted ascending.
+// Intervals which are not aligned to a day (weeks, months) are omitted.
+var nativeIntervals = map[string][]nativeInterval{
+	entity.PlatformBinance: {
+		{time.Minute, "1m"}, {3 * time.Minute, "3m"}, {5 * time.Minute, "5m"}, {15 * time.Minute, "15m"},
+		{30 * time.Minute, "30m"}, {time.Hour, "1h"}, {2 * time.Hour, "2h"}, {4 * time.Hour, "4h"},
+		{6 * time.Hour, "6h"}, {8 * time.Hour, "8h"}, {12 * time.Hour, "12h"}, {24 * time.Hour, "1d"},
+	},
+	entity.PlatformBybit: {
+		{time.Minute, "1"}, {3 * time.Minute, "3"}, {5 * time.Minute, "5"}, {15 * time.Minute, "15"},
+		{30 * time.Minute, "30"}, {time.Hour, "60"}, {2 * time.Hour, "120"}, {4 * time.Hour, "240"},
+		{6 * time.Hour, "360"}, {12 * time.Hour, "720"}, {24 * time.Hour, "D"},
+	},
+}
+
+// resolveInterval returns exchange interval klines should be fetched with: the requested interval if exchange
+// supports it, otherwise the largest supported interval it is divisible by, such klines are aggregated locally.
+func resolveInterval(platform string, interval time.Duration) (nativeInterval, error) {
+	intervals, ok := nativeIntervals[platform]
+	if !ok {
+		return nativeInterval{}, fmt.Errorf("kline intervals of %s are unknown", platform)
+	}
+
+	for i := len(intervals) - 1; i >= 0; i-- {
+		if interval%intervals[i].interval == 0 {
+			return intervals[i], nil
+		}
+	}
+
+	return nativeInterval{}, fmt.Errorf("kline interval %s is not supported by %s", interval, platform)
+}
+
+// fetchIntervalKlinesFunc fetches klines of the exchange interval with open time within [start, end].
+type fetchIntervalKlinesFunc func(start, end time.Time, interval nativeInterval) ([]*entity.Kline, error)
+
+// aggregatingFetcher returns fetcher of klines of the interval, klines of the base interval are aggregated if
+// interval is not supported by exchange natively.
+func aggregatingFetcher(fetch fetchIntervalKlinesFunc, base nativeInterval, interval time.Duration) fetchKlinesFunc {
+	return func(start, end time.Time) ([]*entity.Kline, error) {
+		if base.interval == interval {
+			return fetch(start, end, base)
+		}
+
+		klines, err := fetch(alignTime(start, interval), alignTime(end, interval).Add(interval-base.interval), base)
+		if err != nil {
+			return nil, err
+		}
+
+		var res []*entity.Kline
+		for _, k := range aggregateKlines(klines, base.interval, interval) {
+			if !k.OpenTime.Before(start) && !k.OpenTime.After(end) {
+				res = append(res, k)
+			}
+		}
+
+		return res, nil
+	}
+}
+
+// aggregateKlines merges klines of the base interval into klines of the interval. Buckets are aligned to the
+// unix epoch, so intervals dividing a day start at midnight UTC. Incomplete buckets (missing base klines or the
+// trailing one which is still forming) are dropped.
+func aggregateKlines(klines []*entity.Kline, base, interval time.Duration) []*entity.Kline {
+	perBucket := int(interval / base)
+	klines = sortKlines(klines)
+
+	var (
+		res    []*entity.Kline
+		bucket *entity.Kline
+		count  int
+	)
+	flush := func() {
+		if bucket != nil && count == perBucket {
+			res = append(res, bucket)
+		}
+	}
+
+	for _, k := range klines {
+		openTime := alignTime(k.OpenTime, interval)
+		if bucket == nil || !bucket.OpenTime.Equal(openTime) {
+			flush()
+			bucket = &entity.Kline{
+				OpenTime: openTime,
+				Open:     k.Open,
+				High:     k.High,
+				Low:      k.Low,
+				Volume:   decimal.Zero,
+			}
+			count = 0
+		}
+
+		bucket.High = decimal.Max(bucket.High, k.High)
+		bucket.Low = decimal.Min(bucket.Low, k.Low)
+		bucket.Close = k.Close
+		bucket.Volume = bucket.Volume.Add(k.Volume)
+		count++
+	}
+	flush()
+
+	return res
+}
+
+// alignTime returns start of the interval bucket t belongs to, buckets are aligned to the unix epoch.
+func alignTime(t time.Time, interval time.Duration) time.Time {
+	ms := t.UnixMilli()
+	return time.UnixMilli(ms - ms%interval.Milliseconds())
+}
@@ -0,0 +1,144 @@
+package channel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"github.com/vadiminshakov/marti/entity"
+)
+
+func ohlcv(openTime time.Time, o, h, l, c, v int64) *entity.Kline {
+	return &entity.Kline{
+		OpenTime: openTime,
+		Open:     decimal.NewFromInt(o),
+		High:     decimal.NewFromInt(h),
+		Low:      decimal.NewFromInt(l),
+		Close:    decimal.NewFromInt(c),
+		Volume:   decimal.NewFromInt(v),
+	}
+}
+
+func requireKline(t *testing.T, expected, actual *entity.Kline) {
+	t.Helper()
+
+	require.Equal(t, expected.OpenTime.UTC(), actual.OpenTime.UTC())
+	require.True(t, expected.Open.Equal(actual.Open), "open %s != %s", expected.Open, actual.Open)
+	require.True(t, expected.High.Equal(actual.High), "high %s != %s", expected.High, actual.High)
+	require.True(t, expected.Low.Equal(actual.Low), "low %s != %s", expected.Low, actual.Low)
+	require.True(t, expected.Close.Equal(actual.Close), "close %s != %s", expected.Close, actual.Close)
+	require.True(t, expected.Volume.Equal(actual.Volume), "volume %s != %s", expected.Volume, actual.Volume)
+}
+
+func TestResolveInterval(t *testing.T) {
+	for _, tc := range []struct {
+		platform string
+		interval time.Duration
+		expected string
+	}{
+		{entity.PlatformBinance, 4 * time.Hour, "4h"},
+		{entity.PlatformBinance, 3 * time.Hour, "1h"},
+		{entity.PlatformBinance, 10 * time.Minute, "5m"},
+		{entity.PlatformBinance, 2 * 24 * time.Hour, "1d"},
+		{entity.PlatformBybit, 4 * time.Hour, "240"},
+		{entity.PlatformBybit, 8 * time.Hour, "240"},
+		{entity.PlatformBybit, 7 * time.Minute, "1"},
+	} {
+		base, err := resolveInterval(tc.platform, tc.interval)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, base.name, "%s %s", tc.platform, tc.interval)
+	}
+
+	_, err := resolveInterval(entity.PlatformBinance, 90*time.Second)
+	require.Error(t, err)
+	_, err = resolveInterval(entity.PlatformKraken, time.Hour)
+	require.Error(t, err)
+}
+
+func TestAggregateKlines1mTo3m(t *testing.T) {
+	// day change in the middle, buckets must start at 23:57 and 00:00
+	start := time.Date(2024, 3, 1, 23, 57, 0, 0, time.UTC)
+	klines := []*entity.Kline{
+		ohlcv(start, 10, 12, 9, 11, 5),
+		ohlcv(start.Add(time.Minute), 11, 15, 10, 14, 7),
+		ohlcv(start.Add(2*time.Minute), 14, 14, 8, 9, 3),
+		ohlcv(start.Add(3*time.Minute), 9, 10, 7, 8, 1),
+		ohlcv(start.Add(4*time.Minute), 8, 13, 8, 12, 2),
+		ohlcv(start.Add(5*time.Minute), 12, 12, 11, 11, 4),
+		// trailing incomplete bucket
+		ohlcv(start.Add(6*time.Minute), 11, 20, 1, 15, 100),
+	}
+
+	res := aggregateKlines(klines, time.Minute, 3*time.Minute)
+	require.Len(t, res, 2)
+	requireKline(t, ohlcv(start, 10, 15, 8, 9, 15), res[0])
+	requireKline(t, ohlcv(time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), 9, 13, 7, 11, 7), res[1])
+}
+
+func TestAggregateKlines1mTo15m(t *testing.T) {
+	// klines start in the middle of a bucket, the first bucket is incomplete
+	start := time.Date(2024, 3, 1, 23, 40, 0, 0, time.UTC)
+	var klines []*entity.Kline
+	for i := 0; i < 35; i++ {
+		klines = append(klines, ohlcv(start.Add(time.Duration(i)*time.Minute), int64(100+i), int64(110+i), int64(90+i), int64(101+i), int64(i)))
+	}
+	// shuffled and duplicated klines from backfill
+	klines = append(klines, klines[20], klines[3])
+	klines[10], klines[30] = klines[30], klines[10]
+
+	res := aggregateKlines(klines, time.Minute, 15*time.Minute)
+	require.Len(t, res, 2)
+
+	// 23:45 - 00:00 is klines 5..19, 00:00 - 00:15 is klines 20..34
+	requireKline(t, ohlcv(time.Date(2024, 3, 1, 23, 45, 0, 0, time.UTC), 105, 129, 95, 120, 180), res[0])
+	requireKline(t, ohlcv(time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), 120, 144, 110, 135, 405), res[1])
+}
+
+func TestAggregateKlinesDropsBucketsWithGaps(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	klines := []*entity.Kline{
+		ohlcv(start, 1, 1, 1, 1, 1),
+		ohlcv(start.Add(2*time.Minute), 1, 1, 1, 1, 1),
+		ohlcv(start.Add(3*time.Minute), 1, 1, 1, 1, 1),
+		ohlcv(start.Add(4*time.Minute), 1, 1, 1, 1, 1),
+		ohlcv(start.Add(5*time.Minute), 1, 1, 1, 1, 1),
+	}
+
+	res := aggregateKlines(klines, time.Minute, 3*time.Minute)
+	require.Len(t, res, 1)
+	require.Equal(t, start.Add(3*time.Minute), res[0].OpenTime.UTC())
+}
+
+func TestAggregatingFetcher(t *testing.T) {
+	base := nativeInterval{time.Hour, "1h"}
+	var requested []fetchRequest
+	fetch := func(start, end time.Time, interval nativeInterval) ([]*entity.Kline, error) {
+		require.Equal(t, base, interval)
+		requested = append(requested, fetchRequest{start.UTC(), end.UTC()})
+
+		var res []*entity.Kline
+		for ts := start; !ts.After(end); ts = ts.Add(interval.interval) {
+			res = append(res, ohlcv(ts, 1, 2, 1, 2, 1))
+		}
+		return res, nil
+	}
+
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	klines, err := aggregatingFetcher(fetch, base, 3*time.Hour)(day.Add(time.Hour), day.Add(9*time.Hour))
+	require.NoError(t, err)
+
+	require.Equal(t, []fetchRequest{{day, day.Add(11 * time.Hour)}}, requested)
+	require.Len(t, klines, 3)
+	for i, k := range klines {
+		requireKline(t, ohlcv(day.Add(time.Duration(3*(i+1))*time.Hour), 1, 2, 1, 2, 3), k)
+	}
+
+	// native interval is fetched as is
+	requested = nil
+	base = nativeInterval{3 * time.Hour, "3h"}
+	klines, err = aggregatingFetcher(fetch, base, 3*time.Hour)(day, day.Add(6*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, []fetchRequest{{day, day.Add(6 * time.Hour)}}, requested)
+	require.Len(t, klines, 3)
+}
@@ -0,0 +1,153 @@
+package channel
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vadiminshakov/marti/entity"
+)
+
+// ErrKlineGaps is returned when fetched klines have gaps which can't be backfilled.
+var ErrKlineGaps = errors.New("klines have gaps")
+
+// ErrNotEnoughKlines is returned when exchange has less klines than required for calculation, e.g. for new listings.
+var ErrNotEnoughKlines = errors.New("not enough klines")
+
+// Gap is a range of missing klines, From and To are open times of the first and the last missing kline.
+type Gap struct {
+	From time.Time
+	To   time.Time
+}
+
+// fetchKlinesFunc fetches klines with open time within [start, end].
+type fetchKlinesFunc func(start, end time.Time) ([]*entity.Kline, error)
+
+// fetchContinuousKlines fetches klines for the [start, now] range, removes duplicated klines and the still forming one,
+// then tries to backfill gaps with targeted requests. Gaps include klines missing at the start and the end of the range,
+// e.g. when exchange limit truncates the response. Returns gaps which remain after backfill.
+func fetchContinuousKlines(fetch fetchKlinesFunc, start, now time.Time, interval time.Duration) ([]*entity.Kline, []Gap, error) {
+	klines, err := fetch(start, now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	klines = normalizeKlines(klines, interval, now)
+	gaps := findGaps(klines, start, now, interval)
+	if len(gaps) == 0 {
+		return klines, nil, nil
+	}
+
+	for _, g := range gaps {
+		backfill, err := fetch(g.From, g.To)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to backfill klines from %s to %s", g.From, g.To)
+		}
+		klines = append(klines, backfill...)
+	}
+
+	klines = normalizeKlines(klines, interval, now)
+
+	return klines, findGaps(klines, start, now, interval), nil
+}
+
+// normalizeKlines sorts klines by open time, removes duplicates and the kline which is not closed yet.
+func normalizeKlines(klines []*entity.Kline, interval time.Duration, now time.Time) []*entity.Kline {
+	klines = sortKlines(klines)
+
+	res := make([]*entity.Kline, 0, len(klines))
+	for _, k := range klines {
+		if k.OpenTime.Add(interval).After(now) {
+			continue
+		}
+		res = append(res, k)
+	}
+
+	return res
+}
+
+// sortKlines sorts klines by open time and removes duplicates.
+func sortKlines(klines []*entity.Kline) []*entity.Kline {
+	sort.SliceStable(klines, func(i, j int) bool {
+		return klines[i].OpenTime.Before(klines[j].OpenTime)
+	})
+
+	res := make([]*entity.Kline, 0, len(klines))
+	for _, k := range klines {
+		if len(res) > 0 && res[len(res)-1].OpenTime.Equal(k.OpenTime) {
+			continue
+		}
+		res = append(res, k)
+	}
+
+	return res
+}
+
+// findGaps returns ranges of missing klines with open time within [start, now] which are closed by now,
+// klines must be sorted by open time. No klines at all is not a gap, it is checked by the klines count.
+func findGaps(klines []*entity.Kline, start, now time.Time, interval time.Duration) []Gap {
+	if len(klines) == 0 {
+		return nil
+	}
+
+	var gaps []Gap
+	first := klines[0].OpenTime
+	if missing := first.Sub(start) / interval; missing > 0 {
+		gaps = append(gaps, Gap{From: first.Add(-missing * interval), To: first.Add(-interval)})
+	}
+
+	for i := 1; i < len(klines); i++ {
+		expected := klines[i-1].OpenTime.Add(interval)
+		if klines[i].OpenTime.After(expected) {
+			gaps = append(gaps, Gap{From: expected, To: klines[i].OpenTime.Add(-interval)})
+		}
+	}
+
+	// the kline after the last one is still forming if it is not closed by now
+	last := klines[len(klines)-1].OpenTime
+	if missing := now.Sub(last)/interval - 1; missing > 0 {
+		gaps = append(gaps, Gap{From: last.Add(interval), To: last.Add(missing * interval)})
+	}
+
+	return gaps
+}
+
+// handleZeroVolume drops zero-volume klines or fills their prices with close price of the previous kline,
+// so klines without trades don't narrow the channel. Zero-volume klines before the first traded one can't
+// be filled and are dropped. Klines must be sorted by open time.
+func handleZeroVolume(klines []*entity.Kline, handling entity.ZeroVolumeHandling) []*entity.Kline {
+	if handling != entity.ZeroVolumeSkip && handling != entity.ZeroVolumeFill {
+		return klines
+	}
+
+	res := make([]*entity.Kline, 0, len(klines))
+	for _, k := range klines {
+		if !k.Volume.IsZero() {
+			res = append(res, k)
+			continue
+		}
+		if handling == entity.ZeroVolumeSkip || len(res) == 0 {
+			continue
+		}
+
+		prevClose := res[len(res)-1].Close
+		res = append(res, &entity.Kline{
+			OpenTime: k.OpenTime,
+			Open:     prevClose,
+			High:     prevClose,
+			Low:      prevClose,
+			Close:    prevClose,
+			Volume:   k.Volume,
+		})
+	}
+
+	return res
+}
+
+func checkKlinesCount(klines []*entity.Kline, minKlines int) error {
+	if len(klines) == 0 || len(klines) < minKlines {
+		return errors.Wrapf(ErrNotEnoughKlines, "got %d klines, need at least %d", len(klines), max(minKlines, 1))
+	}
+
+	return nil
+}
@@ -0,0 +1,234 @@
+package channel
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"github.com/vadiminshakov/marti/entity"
+)
+
+var klinesStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func kline(n int) *entity.Kline {
+	return &entity.Kline{
+		OpenTime: klinesStart.Add(time.Duration(n) * klineInterval),
+		Open:     decimal.NewFromInt(int64(100 + n)),
+		Close:    decimal.NewFromInt(int64(110 + n)),
+	}
+}
+
+type fetchRequest struct {
+	start, end time.Time
+}
+
+// fixtureFetcher returns klines with open time within requested range, the first response is fixed.
+type fixtureFetcher struct {
+	first    []*entity.Kline
+	all      []*entity.Kline
+	requests []fetchRequest
+}
+
+func (f *fixtureFetcher) fetch(start, end time.Time) ([]*entity.Kline, error) {
+	f.requests = append(f.requests, fetchRequest{start, end})
+	if len(f.requests) == 1 {
+		return f.first, nil
+	}
+
+	var res []*entity.Kline
+	for _, k := range f.all {
+		if !k.OpenTime.Before(start) && !k.OpenTime.After(end) {
+			res = append(res, k)
+		}
+	}
+	return res, nil
+}
+
+func TestFetchContinuousKlinesBackfill(t *testing.T) {
+	now := klinesStart.Add(5*klineInterval + time.Hour)
+	f := &fixtureFetcher{
+		// kline 2 is missing, kline 3 is duplicated, kline 5 is still forming
+		first: []*entity.Kline{kline(0), kline(1), kline(3), kline(3), kline(4), kline(5)},
+		all:   []*entity.Kline{kline(0), kline(1), kline(2), kline(3), kline(4), kline(5)},
+	}
+
+	klines, gaps, err := fetchContinuousKlines(f.fetch, klinesStart, now, klineInterval)
+	require.NoError(t, err)
+	require.Empty(t, gaps)
+	require.Len(t, klines, 5)
+	for i, k := range klines {
+		require.Equal(t, kline(i).OpenTime, k.OpenTime)
+	}
+
+	require.Len(t, f.requests, 2)
+	require.Equal(t, fetchRequest{kline(2).OpenTime, kline(2).OpenTime}, f.requests[1], "backfill must request only missing kline")
+}
+
+func TestFetchContinuousKlinesGapRemains(t *testing.T) {
+	now := klinesStart.Add(10 * klineInterval)
+	f := &fixtureFetcher{
+		first: []*entity.Kline{kline(0), kline(1), kline(4), kline(5)},
+		all:   []*entity.Kline{kline(0), kline(1), kline(4), kline(5)},
+	}
+
+	klines, gaps, err := fetchContinuousKlines(f.fetch, klinesStart, now, klineInterval)
+	require.NoError(t, err)
+	require.Len(t, klines, 4)
+	require.Equal(t, []Gap{
+		{From: kline(2).OpenTime, To: kline(3).OpenTime},
+		{From: kline(6).OpenTime, To: kline(9).OpenTime},
+	}, gaps)
+	require.Len(t, f.requests, 3)
+}
+
+func TestFetchContinuousKlinesTruncated(t *testing.T) {
+	now := klinesStart.Add(10*klineInterval + time.Hour)
+	all := make([]*entity.Kline, 0, 10)
+	for i := 0; i < 10; i++ {
+		all = append(all, kline(i))
+	}
+	// response is cut by exchange limit at both ends, the tail is not available anymore
+	f := &fixtureFetcher{first: all[2:8], all: all[:8]}
+
+	klines, gaps, err := fetchContinuousKlines(f.fetch, klinesStart, now, klineInterval)
+	require.NoError(t, err)
+	require.Len(t, klines, 8)
+	require.Equal(t, kline(0).OpenTime, klines[0].OpenTime, "missing head must be backfilled")
+	require.Equal(t, []Gap{{From: kline(8).OpenTime, To: kline(9).OpenTime}}, gaps)
+	require.Equal(t, []fetchRequest{
+		{klinesStart, now},
+		{kline(0).OpenTime, kline(1).OpenTime},
+		{kline(8).OpenTime, kline(9).OpenTime},
+	}, f.requests)
+
+	// range start between open times of klines is not a gap
+	f = &fixtureFetcher{first: all[1:], all: all}
+	_, gaps, err = fetchContinuousKlines(f.fetch, klinesStart.Add(time.Hour), now, klineInterval)
+	require.NoError(t, err)
+	require.Empty(t, gaps)
+}
+
+func TestNormalizeKlines(t *testing.T) {
+	now := klinesStart.Add(3 * klineInterval)
+	klines := normalizeKlines([]*entity.Kline{kline(2), kline(0), kline(1), kline(0), kline(3)}, klineInterval, now)
+
+	require.Len(t, klines, 3)
+	require.Equal(t, kline(0).OpenTime, klines[0].OpenTime)
+	require.Equal(t, kline(2).OpenTime, klines[2].OpenTime)
+}
+
+func TestHandleZeroVolume(t *testing.T) {
+	at := func(n int) time.Time { return klinesStart.Add(time.Duration(n) * klineInterval) }
+	klines := []*entity.Kline{
+		// leading kline without trades has no close to be filled with
+		ohlcv(at(0), 90, 90, 90, 90, 0),
+		ohlcv(at(1), 100, 112, 98, 110, 5),
+		ohlcv(at(2), 1, 1, 1, 1, 0),
+		ohlcv(at(3), 110, 121, 108, 120, 3),
+		ohlcv(at(4), 0, 0, 0, 0, 0),
+		ohlcv(at(5), 0, 0, 0, 0, 0),
+		ohlcv(at(6), 120, 131, 118, 130, 2),
+	}
+
+	require.Len(t, handleZeroVolume(klines, entity.ZeroVolumeKeep), len(klines))
+
+	skipped := handleZeroVolume(klines, entity.ZeroVolumeSkip)
+	require.Len(t, skipped, 3)
+	requireKline(t, klines[1], skipped[0])
+	requireKline(t, klines[3], skipped[1])
+	requireKline(t, klines[6], skipped[2])
+
+	filled := handleZeroVolume(klines, entity.ZeroVolumeFill)
+	require.Len(t, filled, 6)
+	requireKline(t, ohlcv(at(2), 110, 110, 110, 110, 0), filled[1])
+	requireKline(t, ohlcv(at(4), 120, 120, 120, 120, 0), filled[3])
+	requireKline(t, ohlcv(at(5), 120, 120, 120, 120, 0), filled[4])
+	require.True(t, klines[2].Close.Equal(decimal.NewFromInt(1)), "source klines must not be changed")
+
+	// channel over traded klines only is not narrowed by klines without trades
+	buyPrice, channel, err := CalcBuyPriceAndChannel(skipped)
+	require.NoError(t, err)
+	require.Equal(t, "115", buyPrice.String())
+	require.Equal(t, "10", channel.String())
+	_, keptChannel, err := CalcBuyPriceAndChannel(handleZeroVolume(klines, entity.ZeroVolumeKeep))
+	require.NoError(t, err)
+	require.True(t, keptChannel.LessThan(channel))
+}
+
+// binanceKlinesServer serves klines with the given indexes, ignoring requested range.
+func binanceKlinesServer(t *testing.T, indexes []int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start, _ := strconv.ParseInt(r.URL.Query().Get("startTime"), 10, 64)
+		end, _ := strconv.ParseInt(r.URL.Query().Get("endTime"), 10, 64)
+
+		res := make([][]any, 0, len(indexes))
+		for _, i := range indexes {
+			k := kline(i)
+			openTime := k.OpenTime.UnixMilli()
+			if openTime < start || openTime > end {
+				continue
+			}
+			res = append(res, []any{openTime, k.Open.String(), "0", "0", k.Close.String(), "0",
+				k.OpenTime.Add(klineInterval).UnixMilli() - 1, "0", 0, "0", "0", "0"})
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(res))
+	}))
+}
+
+func TestBinanceFinderDegraded(t *testing.T) {
+	// klines 0..40 cover the last stat hours, a couple of klines are missing
+	indexes := make([]int, 0, 40)
+	for i := 0; i < 40; i++ {
+		if i == 20 || i == 21 {
+			continue
+		}
+		indexes = append(indexes, i)
+	}
+	srv := binanceKlinesServer(t, indexes)
+	defer srv.Close()
+
+	client := binance.NewClient("", "")
+	client.BaseURL = srv.URL
+	statHours := uint64(time.Since(klinesStart).Hours()) + 1
+
+	f := NewBinanceChannelFinder(client, entity.Pair{From: "BTC", To: "USDT"}, statHours, 0, false, 1)
+	_, _, err := f.GetTradingChannel()
+	require.ErrorIs(t, err, ErrKlineGaps)
+	require.True(t, f.Degraded())
+
+	f = NewBinanceChannelFinder(client, entity.Pair{From: "BTC", To: "USDT"}, statHours, 0, true, 1)
+	_, _, err = f.GetTradingChannel()
+	require.NoError(t, err)
+	require.True(t, f.Degraded())
+}
+
+func TestBinanceFinderNotEnoughKlines(t *testing.T) {
+	srv := binanceKlinesServer(t, []int{0, 1})
+	defer srv.Close()
+
+	client := binance.NewClient("", "")
+	client.BaseURL = srv.URL
+	statHours := uint64(time.Since(klinesStart).Hours()) + 1
+
+	f := NewBinanceChannelFinder(client, entity.Pair{From: "NEW", To: "USDT"}, statHours, 0, false, 5)
+	_, _, err := f.GetTradingChannel()
+	require.ErrorIs(t, err, ErrNotEnoughKlines)
+
+	// fixture klines end long before now, so enough klines are used in degraded mode
+	f = NewBinanceChannelFinder(client, entity.Pair{From: "NEW", To: "USDT"}, statHours, 0, true, 2)
+	_, _, err = f.GetTradingChannel()
+	require.NoError(t, err)
+	require.True(t, f.Degraded())
+}
+
+func TestCheckKlinesCount(t *testing.T) {
+	require.ErrorIs(t, checkKlinesCount(nil, 0), ErrNotEnoughKlines)
+	require.ErrorIs(t, checkKlinesCount([]*entity.Kline{kline(0)}, 2), ErrNotEnoughKlines)
+	require.NoError(t, checkKlinesCount([]*entity.Kline{kline(0), kline(1)}, 2))
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// DailyLossTracker tracks realized and unrealized PnL of all trade services of the process since local midnight
+// and halts trading for the rest of the day once the loss exceeds the limit. Positions opened before the day
+// (or before the process start) are counted from their value at that moment. PnL is summed in the quote currency,
+// so all pairs must be quoted in the same currency. It is safe for concurrent use by several trade services.
+type DailyLossTracker struct {
+	mu       sync.Mutex
+	maxLoss  decimal.Decimal
+	day      time.Time
+	realized decimal.Decimal
+	// cost is the quote notional spent on the open position of the bot
+	cost map[string]decimal.Decimal
+	// value is the last valuation of the open position of the bot
+	value map[string]decimal.Decimal
+	// baseline is unrealized PnL of the open position at the start of the day, it belongs to the previous days
+	baseline map[string]decimal.Decimal
+	halted   bool
+	now      func() time.Time
+}
+
+// NewDailyLossTracker creates tracker halting trading when loss since midnight exceeds maxLoss.
+func NewDailyLossTracker(maxLoss decimal.Decimal) *DailyLossTracker {
+	d := &DailyLossTracker{
+		maxLoss:  maxLoss,
+		cost:     make(map[string]decimal.Decimal),
+		value:    make(map[string]decimal.Decimal),
+		baseline: make(map[string]decimal.Decimal),
+		now:      time.Now,
+	}
+	d.day = startOfDay(d.now())
+
+	return d
+}
+
+// Set sets notional of the bot (see entity.BotID) position found on startup, its PnL is counted from now.
+// Position already tracked is kept, so PnL is not lost when the bot is recreated.
+func (d *DailyLossTracker) Set(bot string, notional decimal.Decimal) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !notional.IsPositive() {
+		d.forget(bot)
+		return
+	}
+	if _, ok := d.cost[bot]; ok {
+		return
+	}
+	d.cost[bot] = notional
+	d.value[bot] = notional
+	d.baseline[bot] = decimal.Zero
+}
+
+// Bought adds notional of the buy to the bot position.
+func (d *DailyLossTracker) Bought(bot string, notional decimal.Decimal) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.cost[bot] = d.cost[bot].Add(notional)
+	d.value[bot] = d.value[bot].Add(notional)
+}
+
+// Revalue sets current value of the bot position.
+func (d *DailyLossTracker) Revalue(bot string, value decimal.Decimal) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.cost[bot]; ok {
+		d.value[bot] = value
+	}
+}
+
+// Sold realizes PnL of the bot position sold for proceeds.
+func (d *DailyLossTracker) Sold(bot string, proceeds decimal.Decimal) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.roll()
+	d.realized = d.realized.Add(proceeds.Sub(d.cost[bot]).Sub(d.baseline[bot]))
+	d.forget(bot)
+}
+
+// PnL returns realized and unrealized PnL of all bots since midnight.
+func (d *DailyLossTracker) PnL() decimal.Decimal {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.roll()
+	return d.pnl()
+}
+
+// Halted reports whether the daily loss limit is exceeded. Once exceeded, trading stays halted until midnight.
+func (d *DailyLossTracker) Halted() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.roll()
+	if !d.halted && d.pnl().Neg().GreaterThan(d.maxLoss) {
+		d.halted = true
+	}
+
+	return d.halted
+}
+
+// roll starts a new day if midnight has passed, unrealized PnL of open positions is left to the previous day.
+func (d *DailyLossTracker) roll() {
+	day := startOfDay(d.now())
+	if !day.After(d.day) {
+		return
+	}
+
+	d.day = day
+	d.realized = decimal.Zero
+	d.halted = false
+	for bot, cost := range d.cost {
+		d.baseline[bot] = d.value[bot].Sub(cost)
+	}
+}
+
+func (d *DailyLossTracker) pnl() decimal.Decimal {
+	pnl := d.realized
+	for bot, cost := range d.cost {
+		pnl = pnl.Add(d.value[bot].Sub(cost).Sub(d.baseline[bot]))
+	}
+
+	return pnl
+}
+
+func (d *DailyLossTracker) forget(bot string) {
+	delete(d.cost, bot)
+	delete(d.value, bot)
+	delete(d.baseline, bot)
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, day := t.Date()
+	return time.Date(y, m, day, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/vadiminshakov/marti/entity"
+	anomalymock "github.com/vadiminshakov/marti/services/anomalydetector/mock"
+	detectormock "github.com/vadiminshakov/marti/services/detector/mock"
+	"go.uber.org/zap"
+)
+
+func TestDailyLossTracker(t *testing.T) {
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	d := NewDailyLossTracker(decimal.NewFromInt(50))
+	d.now = func() time.Time { return now }
+	d.day = startOfDay(now)
+
+	d.Bought("BTC_USDT", decimal.NewFromInt(1000))
+	d.Revalue("BTC_USDT", decimal.NewFromInt(960))
+	require.False(t, d.Halted())
+
+	// realized and unrealized losses of all bots are summed
+	d.Bought("ETH_USDT", decimal.NewFromInt(500))
+	d.Sold("ETH_USDT", decimal.NewFromInt(480))
+	require.Equal(t, "-60", d.PnL().String())
+	require.True(t, d.Halted())
+
+	// recovery within the day doesn't resume trading
+	d.Revalue("BTC_USDT", decimal.NewFromInt(1100))
+	require.True(t, d.Halted())
+
+	// the next day resets the limit, PnL of the open position before midnight belongs to the previous day
+	now = now.Add(16 * time.Hour)
+	require.False(t, d.Halted())
+	require.True(t, d.PnL().IsZero())
+	d.Revalue("BTC_USDT", decimal.NewFromInt(1090))
+	require.Equal(t, "-10", d.PnL().String())
+	d.Sold("BTC_USDT", decimal.NewFromInt(1040))
+	require.Equal(t, "-60", d.PnL().String())
+	require.True(t, d.Halted())
+}
+
+func TestDailyLossTrackerSet(t *testing.T) {
+	d := NewDailyLossTracker(decimal.NewFromInt(50))
+
+	// position found on startup is counted from its current value
+	d.Set("BTC_USDT", decimal.NewFromInt(1000))
+	d.Revalue("BTC_USDT", decimal.NewFromInt(980))
+	require.Equal(t, "-20", d.PnL().String())
+
+	// recreated bot keeps PnL of the tracked position
+	d.Set("BTC_USDT", decimal.NewFromInt(980))
+	require.Equal(t, "-20", d.PnL().String())
+
+	d.Set("BTC_USDT", decimal.Zero)
+	require.True(t, d.PnL().IsZero())
+}
+
+func TestTradeDailyLossFlattens(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	pair := entity.Pair{From: "BTC", To: "USD"}
+	pricer := &dcaPricer{prices: []int64{100, 80, 120}}
+	trader := &quoteTrader{pricer: pricer, balance: decimal.NewFromInt(1000)}
+
+	detector := detectormock.NewDetector(t)
+	detector.On("NeedAction", mock.Anything).Return(entity.ActionBuy, nil)
+
+	anomalyDetector := anomalymock.NewAnomalyDetector(t)
+	anomalyDetector.On("IsAnomaly", mock.Anything).Return(false, nil)
+
+	ts, err := NewTradeService(zap.NewNop(), pair, decimal.NewFromInt(5), pricer, detector, trader, anomalyDetector, nil, WalConfig{})
+	require.NoError(t, err)
+	defer ts.Close()
+	dailyLoss := NewDailyLossTracker(decimal.NewFromInt(1))
+	ts.SetDailyLoss(dailyLoss)
+
+	event, err := ts.Trade()
+	require.NoError(t, err)
+	require.Equal(t, entity.ActionBuy, event.Action)
+
+	// price drop exceeds the limit, the position is sold
+	event, err = ts.Trade()
+	require.NoError(t, err)
+	require.Equal(t, entity.ActionSell, event.Action)
+	require.Equal(t, entity.TradeReasonDailyLoss, event.Reason)
+	require.True(t, ts.Position().IsZero())
+	require.True(t, dailyLoss.PnL().IsNegative())
+
+	// nothing is bought until the next day
+	event, err = ts.Trade()
+	require.NoError(t, err)
+	require.Nil(t, event)
+	require.Equal(t, 1, trader.buys)
+	require.Equal(t, 1, trader.sells)
+}
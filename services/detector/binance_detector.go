@@ -41,7 +41,7 @@ func NewDetector(client *binance.Client, usebalance decimal.Decimal, pair entity
 
 	d := &Detector{pair: pair, buypoint: buypoint, channel: channel}
 
-	p, err := client.NewListPricesService().Symbol(pair.Symbol()).Do(context.Background())
+	p, err := client.NewListPricesService().Symbol(pair.SymbolFor(entity.PlatformBinance)).Do(context.Background())
 	if err != nil {
 		return nil, err
 	}
@@ -66,6 +66,12 @@ func NewDetector(client *binance.Client, usebalance decimal.Decimal, pair entity
 	return d, nil
 }
 
+// SetLastAction overrides last action derived from account balance,
+// it is used when the balance is shared by several bots trading the pair.
+func (d *Detector) SetLastAction(action entity.Action) {
+	d.lastAction = action
+}
+
 func (d *Detector) NeedAction(price decimal.Decimal) (entity.Action, error) {
 	lastact, err := Detect(d.lastAction, d.buypoint, d.channel, price)
 	if err != nil {
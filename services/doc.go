@@ -0,0 +1,11 @@
+// Package services contains the DCA trading engine, it can be embedded into other programs.
+//
+// TradeService is the engine of a single trade pair. It is created by NewTradeService with implementations
+// of Pricer, Detector, Trader and AnomalyDetector, and Trade is called by the embedding program on its own
+// schedule. State of the series is kept in the WAL directory set by WalConfig, so the series survives restarts.
+// Trade events and pairs are defined in package entity.
+//
+// NewTradeService, the interfaces it depends on, the TradeService methods and the entity types are the
+// supported API. Exchange clients in the subpackages and the bot loop of the marti binary are not,
+// they may change between releases.
+package services
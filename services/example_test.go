@@ -0,0 +1,111 @@
+package services_test
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/shopspring/decimal"
+	"github.com/vadiminshakov/marti/entity"
+	"github.com/vadiminshakov/marti/services"
+	"go.uber.org/zap"
+)
+
+// scriptedPricer returns prices one by one.
+type scriptedPricer struct {
+	prices []decimal.Decimal
+}
+
+func (p *scriptedPricer) GetPrice(_ entity.Pair) (decimal.Decimal, error) {
+	price := p.prices[0]
+	p.prices = p.prices[1:]
+	return price, nil
+}
+
+// channelDetector buys below the channel and sells above it.
+type channelDetector struct {
+	buyBelow, sellAbove decimal.Decimal
+	last                entity.Action
+}
+
+func (d *channelDetector) NeedAction(price decimal.Decimal) (entity.Action, error) {
+	switch {
+	case d.last != entity.ActionBuy && price.LessThanOrEqual(d.buyBelow):
+		d.last = entity.ActionBuy
+		return entity.ActionBuy, nil
+	case d.last == entity.ActionBuy && price.GreaterThanOrEqual(d.sellAbove):
+		d.last = entity.ActionSell
+		return entity.ActionSell, nil
+	}
+
+	return entity.ActionNull, nil
+}
+
+func (d *channelDetector) LastAction() entity.Action {
+	return d.last
+}
+
+// paperTrader keeps balances in memory instead of sending orders to exchange.
+type paperTrader struct {
+	quote decimal.Decimal
+}
+
+func (t *paperTrader) Buy(_ decimal.Decimal) error {
+	return nil
+}
+
+func (t *paperTrader) Sell(_ decimal.Decimal) error {
+	return nil
+}
+
+func (t *paperTrader) GetBalance(_ string) (decimal.Decimal, error) {
+	return t.quote, nil
+}
+
+type noAnomalies struct{}
+
+func (noAnomalies) IsAnomaly(_ decimal.Decimal) bool {
+	return false
+}
+
+func ExampleTradeService() {
+	dir, err := os.MkdirTemp("", "marti")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pair := entity.Pair{From: "BTC", To: "USDT"}
+	pricer := &scriptedPricer{prices: []decimal.Decimal{
+		decimal.NewFromInt(100), decimal.NewFromInt(95), decimal.NewFromInt(120),
+	}}
+	detector := &channelDetector{buyBelow: decimal.NewFromInt(100), sellAbove: decimal.NewFromInt(110)}
+	trader := &paperTrader{quote: decimal.NewFromInt(10000)}
+
+	// allocation of 10 BTC is spent by DCA buys of 2 BTC
+	ts, err := services.NewTradeService(zap.NewNop(), pair, decimal.NewFromInt(10), pricer, detector, trader,
+		noAnomalies{}, nil, services.WalConfig{Dir: dir})
+	if err != nil {
+		panic(err)
+	}
+	defer ts.Close()
+
+	// the embedding program drives trade cycles on its own schedule
+	for range 3 {
+		te, err := ts.Trade()
+		if err != nil {
+			panic(err)
+		}
+		if te != nil {
+			fmt.Println(te.Action, te.Reason, te.Amount, "at", te.Price)
+		}
+		fmt.Println("position:", ts.Position())
+	}
+
+	// Output:
+	// ActionBuy entry 2 at 100
+	// position: 2
+	// ActionBuy dca 2 at 95
+	// position: 4
+	// ActionSell take_profit 4 at 120
+	// position: 0
+}
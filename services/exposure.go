@@ -0,0 +1,107 @@
+package services
+
+import (
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+// ExposureTracker tracks quote notional deployed by all trade services of the process
+// and limits it by the configured cap. It is safe for concurrent use by several trade services.
+type ExposureTracker struct {
+	mu       sync.Mutex
+	limit    decimal.Decimal
+	capital  decimal.Decimal
+	deployed map[string]decimal.Decimal
+	skipped  uint64
+}
+
+// NewExposureTracker creates tracker which allows deploying no more than maxPercent of capital across all pairs.
+func NewExposureTracker(capital, maxPercent decimal.Decimal) *ExposureTracker {
+	return &ExposureTracker{
+		limit:    capital.Mul(maxPercent).Div(decimal.NewFromInt(100)),
+		capital:  capital,
+		deployed: make(map[string]decimal.Decimal),
+	}
+}
+
+// Set sets deployed notional of the bot (see entity.BotID), used to rebuild exposure from the state found on startup.
+func (e *ExposureTracker) Set(bot string, notional decimal.Decimal) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.deployed[bot] = notional
+}
+
+// Reserve adds notional to the bot exposure if the global limit allows it.
+// Returns false (and counts the skipped buy) if the limit would be exceeded.
+func (e *ExposureTracker) Reserve(bot string, notional decimal.Decimal) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.total().Add(notional).GreaterThan(e.limit) {
+		e.skipped++
+		return false
+	}
+
+	e.deployed[bot] = e.deployed[bot].Add(notional)
+
+	return true
+}
+
+// Release removes notional from the bot exposure, e.g. if reserved buy failed.
+func (e *ExposureTracker) Release(bot string, notional decimal.Decimal) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	deployed := e.deployed[bot].Sub(notional)
+	if deployed.IsNegative() {
+		deployed = decimal.Zero
+	}
+	e.deployed[bot] = deployed
+}
+
+// Reset clears the bot exposure after the position is sold.
+func (e *ExposureTracker) Reset(bot string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.deployed, bot)
+}
+
+// Deployed returns notional deployed across all pairs.
+func (e *ExposureTracker) Deployed() decimal.Decimal {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.total()
+}
+
+// DeployedPercent returns deployed notional as percent of capital.
+func (e *ExposureTracker) DeployedPercent() decimal.Decimal {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.capital.IsZero() {
+		return decimal.Zero
+	}
+
+	return e.total().Div(e.capital).Mul(decimal.NewFromInt(100))
+}
+
+// Skipped returns number of buys skipped due to the exposure limit.
+func (e *ExposureTracker) Skipped() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.skipped
+}
+
+func (e *ExposureTracker) total() decimal.Decimal {
+	total := decimal.Zero
+	for _, notional := range e.deployed {
+		total = total.Add(notional)
+	}
+
+	return total
+}
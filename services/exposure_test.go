@@ -0,0 +1,120 @@
+package services
+
+import (
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/vadiminshakov/marti/entity"
+	anomalymock "github.com/vadiminshakov/marti/services/anomalydetector/mock"
+	"go.uber.org/zap"
+)
+
+// dippingPricer returns price falling by 1 on every call.
+type dippingPricer struct {
+	price int64
+}
+
+func (p *dippingPricer) GetPrice(_ entity.Pair) (decimal.Decimal, error) {
+	p.price--
+	return decimal.NewFromInt(p.price), nil
+}
+
+// firstBuyDetector asks to buy once and then leaves decisions to DCA logic.
+type firstBuyDetector struct {
+	calls int
+}
+
+func (d *firstBuyDetector) NeedAction(_ decimal.Decimal) (entity.Action, error) {
+	d.calls++
+	if d.calls == 1 {
+		return entity.ActionBuy, nil
+	}
+	return entity.ActionNull, nil
+}
+
+func (d *firstBuyDetector) LastAction() entity.Action {
+	return entity.ActionSell
+}
+
+// walletTrader records quote notional spent by all bots on the shared wallet.
+type walletTrader struct {
+	mu     *sync.Mutex
+	spent  *decimal.Decimal
+	pricer *dippingPricer
+}
+
+func (t *walletTrader) Buy(amount decimal.Decimal) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	*t.spent = t.spent.Add(amount.Mul(decimal.NewFromInt(t.pricer.price)))
+	return nil
+}
+
+func (t *walletTrader) Sell(_ decimal.Decimal) error {
+	return nil
+}
+
+func (t *walletTrader) GetBalance(_ string) (decimal.Decimal, error) {
+	return decimal.NewFromInt(1_000_000), nil
+}
+
+func TestExposureLimitAcrossBots(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	capital := decimal.NewFromInt(1000)
+	exposure := NewExposureTracker(capital, decimal.NewFromInt(60))
+	limit := decimal.NewFromInt(600)
+
+	var (
+		mu    sync.Mutex
+		spent decimal.Decimal
+	)
+
+	l, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	pairs := []entity.Pair{{From: "BTC", To: "USDT"}, {From: "ETH", To: "USDT"}, {From: "BNB", To: "USDT"}}
+	services := make([]*TradeService, 0, len(pairs))
+	for _, pair := range pairs {
+		anomalyDetector := anomalymock.NewAnomalyDetector(t)
+		anomalyDetector.On("IsAnomaly", mock.Anything).Return(false)
+
+		pricer := &dippingPricer{price: 101}
+		// every buy is 2 coins for ~100 USDT, i.e. ~200 USDT notional
+		ts, err := NewTradeService(l, pair, decimal.NewFromInt(10), pricer, &firstBuyDetector{},
+			&walletTrader{mu: &mu, spent: &spent, pricer: pricer}, anomalyDetector, exposure, WalConfig{Dir: WalDir(pair, "")})
+		require.NoError(t, err)
+		defer ts.Close()
+		services = append(services, ts)
+	}
+
+	var wg sync.WaitGroup
+	for _, ts := range services {
+		wg.Add(1)
+		go func(ts *TradeService) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				_, err := ts.Trade()
+				assert.NoError(t, err)
+				assert.True(t, exposure.Deployed().LessThanOrEqual(limit), "deployed notional exceeds the limit")
+			}
+		}(ts)
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.True(t, spent.LessThanOrEqual(limit), "spent %s exceeds the limit %s", spent, limit)
+	assert.True(t, spent.Equal(exposure.Deployed()))
+	assert.Positive(t, exposure.Skipped(), "limit must bind")
+	assert.True(t, exposure.DeployedPercent().LessThanOrEqual(decimal.NewFromInt(60)))
+
+	// selling frees the exposure, so buys are possible again
+	exposure.Reset(pairs[0].String())
+	assert.True(t, exposure.Reserve(pairs[0].String(), decimal.NewFromInt(100)))
+}
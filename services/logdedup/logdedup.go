@@ -0,0 +1,152 @@
+package logdedup
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// priceField is the field whose values are bucketed, so entries differing only by a small price move are identical.
+	priceField = "price"
+	// priceSignificantDigits is the number of significant digits prices are bucketed to.
+	priceSignificantDigits = 3
+	// ExemptLogger is the name of logger (and loggers named under it) whose entries are never collapsed,
+	// it is used for trade execution, so two real trades at close prices are never merged.
+	ExemptLogger = "trade"
+)
+
+// Core wraps zapcore.Core and collapses identical consecutive entries written within the window
+// into the first entry and a summary line with a "repeated N times" suffix.
+// Entries are identical if they have the same level, logger name, message and fields.
+// Distinct entries and entries of ExemptLogger always pass through.
+type Core struct {
+	zapcore.Core
+	fields []zapcore.Field
+	state  *state
+}
+
+// state is shared by the core and cores derived from it with With, so consecutive entries are tracked across them.
+type state struct {
+	mu     sync.Mutex
+	window time.Duration
+	now    func() time.Time
+
+	key        string
+	first      time.Time
+	last       zapcore.Entry
+	lastCore   zapcore.Core
+	lastFields []zapcore.Field
+	repeated   int
+}
+
+// NewCore wraps core collapsing repeated entries within window.
+func NewCore(core zapcore.Core, window time.Duration) *Core {
+	return &Core{Core: core, state: &state{window: window, now: time.Now}}
+}
+
+func (c *Core) With(fields []zapcore.Field) zapcore.Core {
+	return &Core{
+		Core:   c.Core.With(fields),
+		fields: append(append([]zapcore.Field{}, c.fields...), fields...),
+		state:  c.state,
+	}
+}
+
+func (c *Core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *Core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	s := c.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if exempt(ent.LoggerName) {
+		// summary of collapsed entries goes first to keep the order of lines
+		if err := s.flush(); err != nil {
+			return err
+		}
+		return c.Core.Write(ent, fields)
+	}
+
+	key := entryKey(ent, append(append([]zapcore.Field{}, c.fields...), fields...))
+	now := s.now()
+	if key == s.key && now.Sub(s.first) < s.window {
+		s.repeated++
+		s.last = ent
+		return nil
+	}
+
+	if err := s.flush(); err != nil {
+		return err
+	}
+	s.key, s.first, s.last, s.lastCore, s.lastFields = key, now, ent, c.Core, fields
+
+	return c.Core.Write(ent, fields)
+}
+
+// Sync writes summary of collapsed entries and syncs the wrapped core.
+func (c *Core) Sync() error {
+	c.state.mu.Lock()
+	err := c.state.flush()
+	c.state.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return c.Core.Sync()
+}
+
+// flush writes summary of collapsed entries if there are any.
+func (s *state) flush() error {
+	if s.repeated == 0 {
+		return nil
+	}
+
+	ent := s.last
+	ent.Message = fmt.Sprintf("%s (repeated %d times)", ent.Message, s.repeated)
+	s.repeated = 0
+	s.key = ""
+
+	return s.lastCore.Write(ent, s.lastFields)
+}
+
+// exempt reports whether entries of the logger are never collapsed.
+func exempt(loggerName string) bool {
+	return loggerName == ExemptLogger || strings.HasPrefix(loggerName, ExemptLogger+".")
+}
+
+// entryKey returns key which is equal for identical entries.
+func entryKey(ent zapcore.Entry, fields []zapcore.Field) string {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	if price, ok := enc.Fields[priceField]; ok {
+		enc.Fields[priceField] = bucketPrice(price)
+	}
+
+	// maps are printed with sorted keys
+	return fmt.Sprintf("%d|%s|%s|%v", ent.Level, ent.LoggerName, ent.Message, enc.Fields)
+}
+
+// bucketPrice rounds price to priceSignificantDigits significant digits, values which are not numbers are kept.
+func bucketPrice(v interface{}) interface{} {
+	price, err := decimal.NewFromString(fmt.Sprint(v))
+	if err != nil || price.IsZero() {
+		return v
+	}
+
+	digits := int32(len(new(big.Int).Abs(price.Coefficient()).String()))
+	return price.Round(priceSignificantDigits - digits - price.Exponent()).String()
+}
@@ -0,0 +1,91 @@
+package logdedup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func newLogger(window time.Duration) (*zap.Logger, *observer.ObservedLogs, *time.Time) {
+	obs, logs := observer.New(zapcore.DebugLevel)
+	core := NewCore(obs, window)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	core.state.now = func() time.Time { return now }
+
+	return zap.New(core), logs, &now
+}
+
+func messages(logs *observer.ObservedLogs) []string {
+	var res []string
+	for _, e := range logs.All() {
+		res = append(res, e.Message)
+	}
+
+	return res
+}
+
+func TestCoreCollapsesRepeatedEntries(t *testing.T) {
+	l, logs, now := newLogger(time.Minute)
+
+	for i := 0; i < 5; i++ {
+		l.Info("waiting for price to drop", zap.String("pair", "BTC_USDT"), zap.String("price", "67123.45"))
+		*now = now.Add(5 * time.Second)
+	}
+	require.Equal(t, []string{"waiting for price to drop"}, messages(logs))
+
+	l.Error("failed to get price", zap.String("pair", "BTC_USDT"))
+	require.Equal(t, []string{
+		"waiting for price to drop",
+		"waiting for price to drop (repeated 4 times)",
+		"failed to get price",
+	}, messages(logs))
+	require.Equal(t, "BTC_USDT", logs.All()[1].ContextMap()["pair"], "summary must keep fields")
+}
+
+func TestCoreBucketsPrice(t *testing.T) {
+	l, logs, _ := newLogger(time.Minute)
+
+	l.Info("waiting", zap.String("price", "67123.45"))
+	l.Info("waiting", zap.String("price", "67098.1"))
+	l.Info("waiting", zap.String("price", "68000"))
+	l.Sync()
+
+	require.Equal(t, []string{"waiting", "waiting (repeated 1 times)", "waiting"}, messages(logs))
+}
+
+func TestCorePassesDistinctEntries(t *testing.T) {
+	l, logs, now := newLogger(time.Minute)
+
+	l.Info("buy", zap.String("id", "a"))
+	l.Info("buy", zap.String("id", "b"))
+	l.Warn("buy", zap.String("id", "b"))
+	l.With(zap.String("pair", "ETH_USDT")).Warn("buy", zap.String("id", "b"))
+	require.Len(t, logs.All(), 4, "distinct entries must always pass through")
+
+	// window is counted from the first entry
+	l.Info("poll")
+	*now = now.Add(30 * time.Second)
+	l.Info("poll")
+	*now = now.Add(40 * time.Second)
+	l.Info("poll")
+	require.Equal(t, []string{"poll", "poll (repeated 1 times)", "poll"}, messages(logs)[4:])
+}
+
+func TestCoreNeverCollapsesTradeEntries(t *testing.T) {
+	l, logs, _ := newLogger(time.Minute)
+	trade := l.Named(ExemptLogger)
+
+	l.Info("waiting", zap.String("pair", "BTC_USDT"))
+	l.Info("waiting", zap.String("pair", "BTC_USDT"))
+	// two buys at close prices are both logged
+	trade.Info("buy", zap.String("price", "67123.45"))
+	trade.Info("buy", zap.String("price", "67098.1"))
+	trade.Named("sub").Info("sell")
+	trade.Named("sub").Info("sell")
+
+	require.Equal(t, []string{"waiting", "waiting (repeated 1 times)", "buy", "buy", "sell", "sell"}, messages(logs))
+}
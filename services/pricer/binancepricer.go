@@ -16,8 +16,30 @@ func NewPricer(client *binance.Client) *Pricer {
 	return &Pricer{client: client}
 }
 
+// BestPrices returns best bid and best ask prices of order book.
+func (p *Pricer) BestPrices(pair entity.Pair) (decimal.Decimal, decimal.Decimal, error) {
+	tickers, err := p.client.NewListBookTickersService().Symbol(pair.SymbolFor(entity.PlatformBinance)).Do(context.Background())
+	if err != nil {
+		return decimal.Decimal{}, decimal.Decimal{}, err
+	}
+	if len(tickers) == 0 {
+		return decimal.Decimal{}, decimal.Decimal{}, fmt.Errorf("binance API returned empty book ticker for %s", pair.String())
+	}
+
+	bid, err := decimal.NewFromString(tickers[0].BidPrice)
+	if err != nil {
+		return decimal.Decimal{}, decimal.Decimal{}, err
+	}
+	ask, err := decimal.NewFromString(tickers[0].AskPrice)
+	if err != nil {
+		return decimal.Decimal{}, decimal.Decimal{}, err
+	}
+
+	return bid, ask, nil
+}
+
 func (p *Pricer) GetPrice(pair entity.Pair) (decimal.Decimal, error) {
-	prices, err := p.client.NewListPricesService().Symbol(pair.Symbol()).Do(context.Background())
+	prices, err := p.client.NewListPricesService().Symbol(pair.SymbolFor(entity.PlatformBinance)).Do(context.Background())
 	if err != nil {
 		return decimal.Decimal{}, err
 	}
@@ -0,0 +1,81 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"github.com/vadiminshakov/marti/entity"
+)
+
+const tradesSubject = "trades"
+
+type natsConn interface {
+	Publish(subj string, data []byte) error
+	Close()
+}
+
+// tradeEventMessage is a wire representation of entity.TradeEvent.
+type tradeEventMessage struct {
+	Pair          string          `json:"pair"`
+	Action        string          `json:"action"`
+	Reason        string          `json:"reason"`
+	Amount        decimal.Decimal `json:"amount"`
+	Price         decimal.Decimal `json:"price"`
+	CorrelationID string          `json:"correlation_id"`
+}
+
+// NatsPublisher publishes trade events to NATS.
+type NatsPublisher struct {
+	conn          natsConn
+	subjectPrefix string
+}
+
+// NewNatsPublisher connects to NATS server by url, events are published to subjects starting with subjectPrefix.
+// Unreachable server doesn't fail the connection, it is retried in background.
+func NewNatsPublisher(url, subjectPrefix string) (*NatsPublisher, error) {
+	conn, err := nats.Connect(url, nats.Name("marti"), nats.MaxReconnects(-1), nats.RetryOnFailedConnect(true))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to nats %s", url)
+	}
+
+	return &NatsPublisher{conn: conn, subjectPrefix: subjectPrefix}, nil
+}
+
+// WithSubjectPrefix returns publisher sharing the connection which publishes to subjects starting with subjectPrefix.
+func (p *NatsPublisher) WithSubjectPrefix(subjectPrefix string) *NatsPublisher {
+	return &NatsPublisher{conn: p.conn, subjectPrefix: subjectPrefix}
+}
+
+// PublishTradeEvent publishes trade event to the <prefix>.trades.<pair> subject.
+func (p *NatsPublisher) PublishTradeEvent(te *entity.TradeEvent) error {
+	data, err := json.Marshal(tradeEventMessage{
+		Pair:          te.Pair.String(),
+		Action:        te.Action.String(),
+		Reason:        string(te.Reason),
+		Amount:        te.Amount,
+		Price:         te.Price,
+		CorrelationID: te.CorrelationID,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal trade event")
+	}
+
+	return p.conn.Publish(p.subject(te.Pair), data)
+}
+
+// Close closes connection to NATS.
+func (p *NatsPublisher) Close() error {
+	p.conn.Close()
+	return nil
+}
+
+func (p *NatsPublisher) subject(pair entity.Pair) string {
+	if p.subjectPrefix == "" {
+		return fmt.Sprintf("%s.%s", tradesSubject, pair.String())
+	}
+
+	return fmt.Sprintf("%s.%s.%s", p.subjectPrefix, tradesSubject, pair.String())
+}
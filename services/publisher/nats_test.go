@@ -0,0 +1,80 @@
+package publisher
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vadiminshakov/marti/entity"
+)
+
+type connmock struct {
+	subjects []string
+	messages [][]byte
+	err      error
+}
+
+func (c *connmock) Publish(subj string, data []byte) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.subjects = append(c.subjects, subj)
+	c.messages = append(c.messages, data)
+	return nil
+}
+
+func (c *connmock) Close() {}
+
+func TestPublishTradeEvent(t *testing.T) {
+	conn := &connmock{}
+	p := &NatsPublisher{conn: conn, subjectPrefix: "marti"}
+
+	err := p.PublishTradeEvent(&entity.TradeEvent{
+		Action:        entity.ActionBuy,
+		Reason:        entity.TradeReasonDCA,
+		Pair:          entity.Pair{From: "BTC", To: "USDT"},
+		Amount:        decimal.RequireFromString("0.015"),
+		Price:         decimal.RequireFromString("43000.5"),
+		CorrelationID: "9f2c6a1e0b7d4c33",
+	})
+	require.NoError(t, err)
+
+	require.Len(t, conn.messages, 1)
+	assert.Equal(t, "marti.trades.BTC_USDT", conn.subjects[0])
+
+	var msg tradeEventMessage
+	require.NoError(t, json.Unmarshal(conn.messages[0], &msg))
+	assert.Equal(t, "BTC_USDT", msg.Pair)
+	assert.Equal(t, entity.ActionBuy.String(), msg.Action)
+	assert.Equal(t, "dca", msg.Reason)
+	assert.Equal(t, "9f2c6a1e0b7d4c33", msg.CorrelationID)
+	assert.True(t, decimal.RequireFromString("0.015").Equal(msg.Amount))
+	assert.True(t, decimal.RequireFromString("43000.5").Equal(msg.Price))
+}
+
+func TestPublishTradeEventNoPrefix(t *testing.T) {
+	conn := &connmock{}
+	p := &NatsPublisher{conn: conn}
+
+	require.NoError(t, p.PublishTradeEvent(&entity.TradeEvent{Pair: entity.Pair{From: "ETH", To: "USDT"}}))
+	assert.Equal(t, "trades.ETH_USDT", conn.subjects[0])
+}
+
+func TestPublishersShareConnection(t *testing.T) {
+	conn := &connmock{}
+	p := &NatsPublisher{conn: conn, subjectPrefix: "marti"}
+	bot := p.WithSubjectPrefix("dca")
+
+	require.NoError(t, p.PublishTradeEvent(&entity.TradeEvent{Pair: entity.Pair{From: "ETH", To: "USDT"}}))
+	require.NoError(t, bot.PublishTradeEvent(&entity.TradeEvent{Pair: entity.Pair{From: "BTC", To: "USDT"}}))
+	assert.Equal(t, []string{"marti.trades.ETH_USDT", "dca.trades.BTC_USDT"}, conn.subjects)
+}
+
+func TestPublishTradeEventError(t *testing.T) {
+	p := &NatsPublisher{conn: &connmock{err: errors.New("nats: connection closed")}}
+
+	assert.Error(t, p.PublishTradeEvent(&entity.TradeEvent{Pair: entity.Pair{From: "ETH", To: "USDT"}}))
+}
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrBudgetExceeded is returned in fail fast mode if there are no requests left in the budget.
+var ErrBudgetExceeded = errors.New("api request budget exceeded")
+
+// Limiter is a token bucket limiting number of exchange API requests per minute.
+// It is safe for concurrent use, so one limiter may be shared by clients of all bots.
+type Limiter struct {
+	mu       sync.Mutex
+	tokens   float64
+	capacity float64
+	interval time.Duration // time to refill one token
+	last     time.Time
+	failFast bool
+	now      func() time.Time
+}
+
+// NewLimiter creates limiter allowing requestsPerMinute requests. If failFast is true, requests beyond the budget
+// fail with ErrBudgetExceeded, otherwise they wait until the budget is refilled.
+func NewLimiter(requestsPerMinute int, failFast bool) *Limiter {
+	return &Limiter{
+		tokens:   float64(requestsPerMinute),
+		capacity: float64(requestsPerMinute),
+		interval: time.Minute / time.Duration(requestsPerMinute),
+		last:     time.Now(),
+		failFast: failFast,
+		now:      time.Now,
+	}
+}
+
+// Wait acquires a token for one request.
+func (l *Limiter) Wait(ctx context.Context) error {
+	for {
+		wait, ok := l.take()
+		if ok {
+			return nil
+		}
+		if l.failFast {
+			return ErrBudgetExceeded
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// take takes a token if any, otherwise returns time to wait for the next one.
+func (l *Limiter) take() (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.tokens += float64(now.Sub(l.last)) / float64(l.interval)
+	if l.tokens > l.capacity {
+		l.tokens = l.capacity
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0, true
+	}
+
+	return time.Duration((1 - l.tokens) * float64(l.interval)), false
+}
+
+// Transport wraps http transport so that every request made by the exchange client acquires a token first.
+func (l *Limiter) Transport(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if err := l.Wait(r.Context()); err != nil {
+			return nil, errors.Wrapf(err, "%s %s", r.Method, r.URL.Path)
+		}
+		return next.RoundTrip(r)
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
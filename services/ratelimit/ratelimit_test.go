@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func newTestLimiter(requestsPerMinute int, failFast bool) (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Now()}
+	l := NewLimiter(requestsPerMinute, failFast)
+	l.now = clock.now
+	l.last = clock.t
+
+	return l, clock
+}
+
+func TestLimiterFailFast(t *testing.T) {
+	l, clock := newTestLimiter(3, true)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, l.Wait(context.Background()))
+	}
+	require.ErrorIs(t, l.Wait(context.Background()), ErrBudgetExceeded)
+
+	// one token is refilled every 20s
+	clock.t = clock.t.Add(20 * time.Second)
+	require.NoError(t, l.Wait(context.Background()))
+	require.ErrorIs(t, l.Wait(context.Background()), ErrBudgetExceeded)
+
+	// budget is never refilled beyond capacity
+	clock.t = clock.t.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, l.Wait(context.Background()))
+	}
+	require.ErrorIs(t, l.Wait(context.Background()), ErrBudgetExceeded)
+}
+
+func TestLimiterBlocks(t *testing.T) {
+	l := NewLimiter(600, false) // one token every 100ms
+
+	for i := 0; i < 600; i++ {
+		require.NoError(t, l.Wait(context.Background()))
+	}
+
+	start := time.Now()
+	require.NoError(t, l.Wait(context.Background()))
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond, "request beyond the budget must be throttled")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, l.Wait(ctx), context.DeadlineExceeded)
+}
+
+func TestTransport(t *testing.T) {
+	var served atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served.Add(1)
+	}))
+	defer srv.Close()
+
+	l, _ := newTestLimiter(2, true)
+	client := &http.Client{Transport: l.Transport(http.DefaultTransport)}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(srv.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	_, err := client.Get(srv.URL)
+	require.ErrorIs(t, err, ErrBudgetExceeded)
+	require.Equal(t, int32(2), served.Load())
+}
@@ -0,0 +1,19 @@
+package services
+
+import "sync/atomic"
+
+// BotStatus holds counters of the bot which survive recreation of its trade service.
+// It is safe for concurrent use.
+type BotStatus struct {
+	hangs atomic.Int64
+}
+
+// RecordHang counts trade cycle which missed its deadline and returns the number of hangs since the bot is started.
+func (s *BotStatus) RecordHang() int64 {
+	return s.hangs.Add(1)
+}
+
+// Hangs returns the number of trade cycles which missed their deadline since the bot is started.
+func (s *BotStatus) Hangs() int64 {
+	return s.hangs.Load()
+}
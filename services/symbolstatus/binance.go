@@ -0,0 +1,70 @@
+package symbolstatus
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/vadiminshakov/marti/entity"
+)
+
+// BinanceChecker checks trading status of trade pair on Binance.
+type BinanceChecker struct {
+	client *binance.Client
+	pair   entity.Pair
+}
+
+func NewBinanceChecker(client *binance.Client, pair entity.Pair) *BinanceChecker {
+	return &BinanceChecker{client: client, pair: pair}
+}
+
+// Status returns current trading status of the pair.
+func (c *BinanceChecker) Status() (entity.SymbolStatus, error) {
+	symbol := c.pair.SymbolFor(entity.PlatformBinance)
+	info, err := c.client.NewExchangeInfoService().Symbol(symbol).Do(context.Background())
+	if err != nil {
+		return "", err
+	}
+
+	for _, s := range info.Symbols {
+		if s.Symbol == symbol {
+			return entity.SymbolStatus(s.Status), nil
+		}
+	}
+
+	return "", fmt.Errorf("binance API returned no exchange info for %s", c.pair.String())
+}
+
+// maxCloseMatches limits listed pairs suggested when the pair is not listed.
+const maxCloseMatches = 5
+
+// CheckListed returns error with close matches if the pair is not listed on Binance.
+func (c *BinanceChecker) CheckListed() error {
+	info, err := c.client.NewExchangeInfoService().Do(context.Background())
+	if err != nil {
+		return err
+	}
+
+	symbol := c.pair.SymbolFor(entity.PlatformBinance)
+	var matches []string
+	for _, s := range info.Symbols {
+		if s.Symbol == symbol {
+			return nil
+		}
+		if s.BaseAsset == c.pair.From || s.BaseAsset == c.pair.To && s.QuoteAsset == c.pair.From {
+			matches = append(matches, s.BaseAsset+"_"+s.QuoteAsset)
+		}
+	}
+
+	if len(matches) == 0 {
+		return fmt.Errorf("pair %s is not listed on binance", c.pair.String())
+	}
+	sort.Strings(matches)
+	if len(matches) > maxCloseMatches {
+		matches = matches[:maxCloseMatches]
+	}
+
+	return fmt.Errorf("pair %s is not listed on binance, close matches: %s", c.pair.String(), strings.Join(matches, ", "))
+}
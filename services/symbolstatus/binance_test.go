@@ -0,0 +1,76 @@
+package symbolstatus
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/vadiminshakov/marti/entity"
+)
+
+func exchangeInfoServer(t *testing.T, statuses map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		symbol := r.URL.Query().Get("symbol")
+		res := map[string]any{"symbols": []map[string]any{}}
+		if status, ok := statuses[symbol]; ok {
+			res["symbols"] = []map[string]any{{"symbol": symbol, "status": status}}
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(res))
+	}))
+}
+
+func TestBinanceCheckerStatus(t *testing.T) {
+	statuses := map[string]string{"BTCUSDT": "TRADING"}
+	srv := exchangeInfoServer(t, statuses)
+	defer srv.Close()
+
+	client := binance.NewClient("", "")
+	client.BaseURL = srv.URL
+
+	checker := NewBinanceChecker(client, entity.Pair{From: "BTC", To: "USDT"})
+
+	status, err := checker.Status()
+	require.NoError(t, err)
+	require.Equal(t, entity.SymbolStatusTrading, status)
+	require.False(t, status.Halted())
+
+	statuses["BTCUSDT"] = "BREAK"
+	status, err = checker.Status()
+	require.NoError(t, err)
+	require.Equal(t, entity.SymbolStatusBreak, status)
+	require.True(t, status.Halted())
+
+	delete(statuses, "BTCUSDT")
+	_, err = checker.Status()
+	require.Error(t, err)
+}
+
+func TestBinanceCheckerCheckListed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Empty(t, r.URL.Query().Get("symbol"))
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]any{"symbols": []map[string]any{
+			{"symbol": "BTCUSDT", "baseAsset": "BTC", "quoteAsset": "USDT"},
+			{"symbol": "BTCFDUSD", "baseAsset": "BTC", "quoteAsset": "FDUSD"},
+			{"symbol": "ETHBTC", "baseAsset": "ETH", "quoteAsset": "BTC"},
+			{"symbol": "ETHUSDT", "baseAsset": "ETH", "quoteAsset": "USDT"},
+		}}))
+	}))
+	defer srv.Close()
+
+	client := binance.NewClient("", "")
+	client.BaseURL = srv.URL
+
+	require.NoError(t, NewBinanceChecker(client, entity.Pair{From: "BTC", To: "USDT"}).CheckListed())
+
+	err := NewBinanceChecker(client, entity.Pair{From: "BTC", To: "USDC"}).CheckListed()
+	require.EqualError(t, err, "pair BTC_USDC is not listed on binance, close matches: BTC_FDUSD, BTC_USDT")
+
+	err = NewBinanceChecker(client, entity.Pair{From: "BTC", To: "ETH"}).CheckListed()
+	require.ErrorContains(t, err, "close matches: BTC_FDUSD, BTC_USDT, ETH_BTC")
+
+	err = NewBinanceChecker(client, entity.Pair{From: "DOGE", To: "TRY"}).CheckListed()
+	require.EqualError(t, err, "pair DOGE_TRY is not listed on binance")
+}
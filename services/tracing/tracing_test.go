@@ -0,0 +1,25 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestSetup(t *testing.T) {
+	// nothing is installed without endpoint
+	shutdown, err := Setup("")
+	require.NoError(t, err)
+	require.NoError(t, shutdown(context.Background()))
+	_, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider)
+	require.False(t, ok)
+
+	shutdown, err = Setup("http://localhost:4318")
+	require.NoError(t, err)
+	_, ok = otel.GetTracerProvider().(*sdktrace.TracerProvider)
+	require.True(t, ok)
+	require.NoError(t, shutdown(context.Background()))
+}
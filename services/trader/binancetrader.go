@@ -2,25 +2,54 @@ package trader
 
 import (
 	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/pkg/errors"
 	"github.com/shopspring/decimal"
 	"github.com/vadiminshakov/marti/entity"
 )
 
+const (
+	// errCodeUnknownOrder is returned by Binance when the order to cancel doesn't exist (filled, canceled or never placed).
+	errCodeUnknownOrder = -2011
+	// errCodeNoSuchOrder is returned by Binance when the queried order doesn't exist.
+	errCodeNoSuchOrder = -2013
+	// defaultOrderPrefix is prepended to client order ids if no other prefix is set.
+	defaultOrderPrefix = "marti-"
+	// maxClientOrderIDLen is the max length of client order id accepted by Binance.
+	maxClientOrderIDLen = 36
+)
+
 type Trader struct {
-	client *binance.Client
-	pair   entity.Pair
+	client      *binance.Client
+	pair        entity.Pair
+	orderPrefix string
+	instanceID  string
 }
 
 func NewTrader(client *binance.Client, pair entity.Pair) (*Trader, error) {
-	return &Trader{pair: pair, client: client}, nil
+	return &Trader{pair: pair, client: client, orderPrefix: defaultOrderPrefix}, nil
+}
+
+// SetInstanceID sets id of the bot instance, it keeps trailing stops of instances trading the same pair apart.
+func (t *Trader) SetInstanceID(instanceID string) {
+	t.instanceID = instanceID
+}
+
+// SetClientOrderPrefix sets prefix of client order ids making orders of the bot recognizable on exchange.
+func (t *Trader) SetClientOrderPrefix(prefix string) {
+	t.orderPrefix = prefix
 }
 
 func (t *Trader) Buy(amount decimal.Decimal) error {
 	amount = amount.RoundFloor(4)
-	_, err := t.client.NewCreateOrderService().Symbol(t.pair.Symbol()).
+	_, err := t.client.NewCreateOrderService().Symbol(t.pair.SymbolFor(entity.PlatformBinance)).
 		Side(binance.SideTypeBuy).Type(binance.OrderTypeMarket).
 		Quantity(amount.String()).
+		NewClientOrderID(t.newOrderID()).
 		Do(context.Background())
 
 	return err
@@ -28,10 +57,157 @@ func (t *Trader) Buy(amount decimal.Decimal) error {
 
 func (t *Trader) Sell(amount decimal.Decimal) error {
 	amount = amount.RoundFloor(4)
-	_, err := t.client.NewCreateOrderService().Symbol(t.pair.Symbol()).
+	_, err := t.client.NewCreateOrderService().Symbol(t.pair.SymbolFor(entity.PlatformBinance)).
 		Side(binance.SideTypeSell).Type(binance.OrderTypeMarket).
 		Quantity(amount.String()).
+		NewClientOrderID(t.newOrderID()).
 		Do(context.Background())
 
 	return err
 }
+
+// GetBalance returns free balance of currency.
+func (t *Trader) GetBalance(currency string) (decimal.Decimal, error) {
+	balance, err := t.GetBalanceDetailed(currency)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	return balance.Free, nil
+}
+
+// GetBalanceDetailed returns free and locked in open orders balance of currency.
+func (t *Trader) GetBalanceDetailed(currency string) (entity.Balance, error) {
+	res, err := t.client.NewGetAccountService().Do(context.Background())
+	if err != nil {
+		return entity.Balance{}, err
+	}
+
+	for _, b := range res.Balances {
+		if b.Asset == currency {
+			return parseBalance(b.Free, b.Locked)
+		}
+	}
+
+	return entity.Balance{}, nil
+}
+
+func parseBalance(free, locked string) (entity.Balance, error) {
+	f, err := decimal.NewFromString(free)
+	if err != nil {
+		return entity.Balance{}, errors.Wrap(err, "invalid free balance")
+	}
+	l, err := decimal.NewFromString(locked)
+	if err != nil {
+		return entity.Balance{}, errors.Wrap(err, "invalid locked balance")
+	}
+
+	return entity.Balance{Free: f, Locked: l}, nil
+}
+
+// SetTrailingStop places native trailing stop sell order for amount, replacing the previous one.
+// The order follows the highest price since placement and sells at market once price falls by callbackPercent.
+func (t *Trader) SetTrailingStop(amount, callbackPercent decimal.Decimal) error {
+	if err := t.CancelTrailingStop(); err != nil {
+		return err
+	}
+
+	// trailing delta is set in basis points
+	delta := callbackPercent.Mul(decimal.NewFromInt(100)).Round(0)
+	_, err := t.client.NewCreateOrderService().Symbol(t.pair.SymbolFor(entity.PlatformBinance)).
+		Side(binance.SideTypeSell).Type(binance.OrderTypeStopLoss).
+		Quantity(amount.RoundFloor(4).String()).
+		TrailingDelta(delta.String()).
+		NewClientOrderID(t.trailingStopID()).
+		Do(context.Background())
+
+	return err
+}
+
+// CancelTrailingStop cancels trailing stop order placed by SetTrailingStop if it is still open.
+func (t *Trader) CancelTrailingStop() error {
+	_, err := t.client.NewCancelOrderService().Symbol(t.pair.SymbolFor(entity.PlatformBinance)).
+		OrigClientOrderID(t.trailingStopID()).
+		Do(context.Background())
+
+	var apiErr *common.APIError
+	if errors.As(err, &apiErr) && apiErr.Code == errCodeUnknownOrder {
+		return nil
+	}
+
+	return err
+}
+
+// TrailingStopFill returns executed amount and average price of the trailing stop placed by SetTrailingStop,
+// amount is zero if the stop is not filled or doesn't exist.
+func (t *Trader) TrailingStopFill() (decimal.Decimal, decimal.Decimal, error) {
+	order, err := t.getOrder(t.trailingStopID())
+	if err != nil {
+		return decimal.Zero, decimal.Zero, err
+	}
+	if order == nil || order.Status != binance.OrderStatusTypeFilled {
+		return decimal.Zero, decimal.Zero, nil
+	}
+
+	amount, err := decimal.NewFromString(order.ExecutedQuantity)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, errors.Wrap(err, "invalid executed quantity of trailing stop")
+	}
+	quote, err := decimal.NewFromString(order.CummulativeQuoteQuantity)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, errors.Wrap(err, "invalid quote quantity of trailing stop")
+	}
+	if !amount.IsPositive() {
+		return decimal.Zero, decimal.Zero, nil
+	}
+
+	return amount, quote.Div(amount), nil
+}
+
+// trailingStopID returns client order id of the trailing stop, it is the same for every stop of the pair
+// and instance, so the stop can be canceled after restart.
+func (t *Trader) trailingStopID() string {
+	id := "ts_" + t.pair.SymbolFor(entity.PlatformBinance)
+	if t.instanceID == "" {
+		return t.clientOrderID(id)
+	}
+
+	instance := t.instanceID
+	if len(t.orderPrefix)+len(id)+1+len(instance) > maxClientOrderIDLen {
+		// cut id of long instance could match id of another instance, so it is hashed
+		sum := sha256.Sum256([]byte(instance))
+		instance = hex.EncodeToString(sum[:4])
+	}
+
+	return t.clientOrderID(id + "_" + instance)
+}
+
+// getOrder returns order by client order id, nil if the order doesn't exist.
+func (t *Trader) getOrder(id string) (*binance.Order, error) {
+	order, err := t.client.NewGetOrderService().Symbol(t.pair.SymbolFor(entity.PlatformBinance)).
+		OrigClientOrderID(id).
+		Do(context.Background())
+	var apiErr *common.APIError
+	if errors.As(err, &apiErr) && apiErr.Code == errCodeNoSuchOrder {
+		return nil, nil
+	}
+
+	return order, err
+}
+
+// newOrderID returns unique client order id.
+func (t *Trader) newOrderID() string {
+	b := make([]byte, 8)
+	_, _ = crand.Read(b)
+	return t.clientOrderID(hex.EncodeToString(b))
+}
+
+// clientOrderID prepends prefix to id, the result is cut to the length accepted by exchange.
+func (t *Trader) clientOrderID(id string) string {
+	id = t.orderPrefix + id
+	if len(id) > maxClientOrderIDLen {
+		id = id[:maxClientOrderIDLen]
+	}
+
+	return id
+}
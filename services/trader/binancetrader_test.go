@@ -0,0 +1,184 @@
+package trader
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"github.com/vadiminshakov/marti/entity"
+)
+
+type orderRequest struct {
+	method string
+	params url.Values
+}
+
+func ordersServer(t *testing.T, requests *[]orderRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v3/order", r.URL.Path)
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		// go-binance sends DELETE parameters in the body, which ParseForm ignores
+		params, err := url.ParseQuery(string(body))
+		require.NoError(t, err)
+		for k, v := range r.URL.Query() {
+			params[k] = v
+		}
+		*requests = append(*requests, orderRequest{method: r.Method, params: params})
+
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"code":-2011,"msg":"Unknown order sent."}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"symbol":"BTCUSDT","orderId":1}`))
+	}))
+}
+
+func TestSetTrailingStop(t *testing.T) {
+	var requests []orderRequest
+	srv := ordersServer(t, &requests)
+	defer srv.Close()
+
+	client := binance.NewClient("key", "secret")
+	client.BaseURL = srv.URL
+	trader, err := NewTrader(client, entity.Pair{From: "BTC", To: "USDT"})
+	require.NoError(t, err)
+
+	require.NoError(t, trader.SetTrailingStop(decimal.RequireFromString("0.123456"), decimal.RequireFromString("1.5")))
+	require.Len(t, requests, 2)
+
+	// previous stop is canceled first, missing order is not an error
+	require.Equal(t, http.MethodDelete, requests[0].method)
+	require.Equal(t, "BTCUSDT", requests[0].params.Get("symbol"))
+	require.Equal(t, "marti-ts_BTCUSDT", requests[0].params.Get("origClientOrderId"))
+
+	order := requests[1]
+	require.Equal(t, http.MethodPost, order.method)
+	require.Equal(t, "BTCUSDT", order.params.Get("symbol"))
+	require.Equal(t, "SELL", order.params.Get("side"))
+	require.Equal(t, "STOP_LOSS", order.params.Get("type"))
+	require.Equal(t, "0.1234", order.params.Get("quantity"))
+	require.Equal(t, "150", order.params.Get("trailingDelta"))
+	require.Equal(t, "marti-ts_BTCUSDT", order.params.Get("newClientOrderId"))
+	require.Empty(t, order.params.Get("stopPrice"))
+}
+
+func TestTrailingStopFill(t *testing.T) {
+	var response string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v3/order", r.URL.Path)
+		require.Equal(t, http.MethodGet, r.Method)
+		require.Equal(t, "marti-ts_BTCUSDT", r.URL.Query().Get("origClientOrderId"))
+		if response == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"code":-2013,"msg":"Order does not exist."}`))
+			return
+		}
+		_, _ = w.Write([]byte(response))
+	}))
+	defer srv.Close()
+
+	client := binance.NewClient("key", "secret")
+	client.BaseURL = srv.URL
+	trader, err := NewTrader(client, entity.Pair{From: "BTC", To: "USDT"})
+	require.NoError(t, err)
+
+	// missing stop is not filled
+	amount, _, err := trader.TrailingStopFill()
+	require.NoError(t, err)
+	require.True(t, amount.IsZero())
+
+	response = `{"symbol":"BTCUSDT","status":"NEW","executedQty":"0","cummulativeQuoteQty":"0"}`
+	amount, _, err = trader.TrailingStopFill()
+	require.NoError(t, err)
+	require.True(t, amount.IsZero())
+
+	response = `{"symbol":"BTCUSDT","status":"FILLED","executedQty":"0.5","cummulativeQuoteQty":"49.25"}`
+	amount, price, err := trader.TrailingStopFill()
+	require.NoError(t, err)
+	require.Equal(t, "0.5", amount.String())
+	require.Equal(t, "98.5", price.String())
+}
+
+func TestTrailingStopIDOfInstances(t *testing.T) {
+	pair := entity.Pair{From: "BTC", To: "USDT"}
+	newTrader := func(instanceID string) *Trader {
+		trader, err := NewTrader(nil, pair)
+		require.NoError(t, err)
+		trader.SetInstanceID(instanceID)
+		return trader
+	}
+
+	require.Equal(t, "marti-ts_BTCUSDT", newTrader("").trailingStopID())
+	require.Equal(t, "marti-ts_BTCUSDT_fast", newTrader("fast").trailingStopID())
+
+	// long ids must not collide after cutting to exchange length limit
+	a, b := newTrader("a_very_long_instance_id_1"), newTrader("a_very_long_instance_id_2")
+	require.NotEqual(t, a.trailingStopID(), b.trailingStopID())
+	require.LessOrEqual(t, len(a.trailingStopID()), maxClientOrderIDLen)
+}
+
+func TestClientOrderPrefix(t *testing.T) {
+	var requests []orderRequest
+	srv := ordersServer(t, &requests)
+	defer srv.Close()
+
+	client := binance.NewClient("key", "secret")
+	client.BaseURL = srv.URL
+	trader, err := NewTrader(client, entity.Pair{From: "BTC", To: "USDT"})
+	require.NoError(t, err)
+	trader.SetClientOrderPrefix("dca-btc-")
+
+	require.NoError(t, trader.Buy(decimal.NewFromInt(1)))
+	require.NoError(t, trader.Sell(decimal.NewFromInt(1)))
+	require.Len(t, requests, 2)
+	buyID, sellID := requests[0].params.Get("newClientOrderId"), requests[1].params.Get("newClientOrderId")
+	require.Regexp(t, "^dca-btc-[0-9a-f]{16}$", buyID)
+	require.Regexp(t, "^dca-btc-[0-9a-f]{16}$", sellID)
+	require.NotEqual(t, buyID, sellID)
+
+	// stop is canceled by the same id it is placed with
+	requests = nil
+	require.NoError(t, trader.SetTrailingStop(decimal.NewFromInt(1), decimal.NewFromInt(1)))
+	require.NoError(t, trader.CancelTrailingStop())
+	require.Len(t, requests, 3)
+	require.Equal(t, "dca-btc-ts_BTCUSDT", requests[1].params.Get("newClientOrderId"))
+	require.Equal(t, "dca-btc-ts_BTCUSDT", requests[2].params.Get("origClientOrderId"))
+
+	// ids are kept within exchange length limit
+	trader.SetClientOrderPrefix("a_very_long_prefix_of_client_orders_")
+	require.Len(t, trader.newOrderID(), maxClientOrderIDLen)
+}
+
+func TestGetBalanceDetailed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v3/account", r.URL.Path)
+		_, _ = w.Write([]byte(`{"balances":[{"asset":"BTC","free":"0.5","locked":"0"},{"asset":"USDT","free":"120.5","locked":"300"}]}`))
+	}))
+	defer srv.Close()
+
+	client := binance.NewClient("key", "secret")
+	client.BaseURL = srv.URL
+	trader, err := NewTrader(client, entity.Pair{From: "BTC", To: "USDT"})
+	require.NoError(t, err)
+
+	balance, err := trader.GetBalanceDetailed("USDT")
+	require.NoError(t, err)
+	require.Equal(t, "120.5", balance.Free.String())
+	require.Equal(t, "300", balance.Locked.String())
+	require.Equal(t, "420.5", balance.Total().String())
+
+	free, err := trader.GetBalance("USDT")
+	require.NoError(t, err)
+	require.Equal(t, "120.5", free.String())
+
+	balance, err = trader.GetBalanceDetailed("ETH")
+	require.NoError(t, err)
+	require.True(t, balance.Total().IsZero())
+}
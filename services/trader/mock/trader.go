@@ -26,6 +26,30 @@ func (_m *Trader) Buy(amount decimal.Decimal) error {
 	return r0
 }
 
+// GetBalance provides a mock function with given fields: currency
+func (_m *Trader) GetBalance(currency string) (decimal.Decimal, error) {
+	ret := _m.Called(currency)
+
+	var r0 decimal.Decimal
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string) (decimal.Decimal, error)); ok {
+		return rf(currency)
+	}
+	if rf, ok := ret.Get(0).(func(string) decimal.Decimal); ok {
+		r0 = rf(currency)
+	} else {
+		r0 = ret.Get(0).(decimal.Decimal)
+	}
+
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(currency)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Sell provides a mock function with given fields: amount
 func (_m *Trader) Sell(amount decimal.Decimal) error {
 	ret := _m.Called(amount)
@@ -1,17 +1,65 @@
 package services
 
 import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/shopspring/decimal"
 	"github.com/vadiminshakov/marti/entity"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"math/rand"
+	"sync/atomic"
+	"time"
 )
 
 const (
 	maxDcaTrades            = 5
 	dcaPercentThresholdBuy  = 0.1
 	dcaPercentThresholdSell = 1
+	// positionMismatchPercent is the difference between tracked position and exchange balance
+	// which is considered significant by reconciliation
+	positionMismatchPercent = 1
+)
+
+// tracer traces trade cycles, spans are dropped unless tracing.Setup installed an exporter.
+var tracer = otel.Tracer("github.com/vadiminshakov/marti/services")
+
+// Phase is the step of trade cycle, it tells where a hung cycle is stuck.
+type Phase int32
+
+const (
+	PhaseIdle Phase = iota
+	PhasePricing
+	PhaseDetection
+	PhaseExecution
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhasePricing:
+		return "pricing"
+	case PhaseDetection:
+		return "detection"
+	case PhaseExecution:
+		return "execution"
+	default:
+		return "idle"
+	}
+}
+
+var (
+	// ErrInsufficientBalance is returned when free quote balance doesn't cover the buy.
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	// ErrBuyBlocked is returned by traders refusing the buy by a safety limit, the buy is skipped.
+	ErrBuyBlocked = errors.New("buy is blocked")
+	// ErrStopped is returned by trade cycle of stopped service instead of placing an order.
+	ErrStopped = errors.New("trade service is stopped")
 )
 
 // Detector checks need to buy, sell assets or do nothing. This service must be
@@ -34,6 +82,31 @@ type Trader interface {
 	Buy(amount decimal.Decimal) error
 	// Sell sells amount of asset in trade pair.
 	Sell(amount decimal.Decimal) error
+	// GetBalance returns free balance of currency.
+	GetBalance(currency string) (decimal.Decimal, error)
+}
+
+// DetailedBalancer is implemented by traders which report balance locked in open orders.
+type DetailedBalancer interface {
+	// GetBalanceDetailed returns free and locked balance of currency.
+	GetBalanceDetailed(currency string) (entity.Balance, error)
+}
+
+// Quoter provides best prices of order book the market orders are filled at.
+type Quoter interface {
+	// BestPrices returns best bid and best ask prices of trade pair.
+	BestPrices(pair entity.Pair) (bid, ask decimal.Decimal, err error)
+}
+
+// TrailingStopper places exchange-side trailing stop orders protecting the position.
+type TrailingStopper interface {
+	// SetTrailingStop replaces current trailing stop with a new one for amount of asset.
+	SetTrailingStop(amount, callbackPercent decimal.Decimal) error
+	// CancelTrailingStop cancels current trailing stop if any.
+	CancelTrailingStop() error
+	// TrailingStopFill returns executed amount and average price of current trailing stop,
+	// amount is zero if the stop is not filled.
+	TrailingStopFill() (amount, price decimal.Decimal, err error)
 }
 
 type AnomalyDetector interface {
@@ -49,24 +122,57 @@ type wal interface {
 
 // TradeService makes trades for specific trade pair.
 type TradeService struct {
+	// id is the bot id, see entity.BotID
+	id              string
 	pair            entity.Pair
 	amount          decimal.Decimal
 	lastBuyPrice    decimal.Decimal
 	tradePart       decimal.Decimal
+	bought          decimal.Decimal
 	pricer          Pricer
 	detector        Detector
 	trader          Trader
 	anomalyDetector AnomalyDetector
 	l               *zap.Logger
 	wal             wal
+	exposure        *ExposureTracker
+	dailyLoss       *DailyLossTracker
 
 	noTrades bool
+
+	sizeJitter   decimal.Decimal
+	rng          *rand.Rand
+	quoteReserve decimal.Decimal
+	// initialBuyNotional is quote amount of the first buy of series, zero means it is derived from allocation
+	initialBuyNotional decimal.Decimal
+
+	stopper      TrailingStopper
+	trailingStop decimal.Decimal
+
+	roundAmount    bool
+	amountDecimals int32
+
+	quoter      Quoter
+	maxSlippage decimal.Decimal
+
+	maxPriceAge    time.Duration
+	priceFetchedAt time.Time
+	lastPrice      decimal.Decimal
+	now            func() time.Time
+
+	// phase is read concurrently by the watchdog of hung cycles
+	phase atomic.Int32
+	// stopped is set concurrently when a hung cycle is abandoned
+	stopped atomic.Bool
+	// halted is set concurrently by the symbol status check, also while an abandoned cycle is running
+	halted atomic.Bool
 }
 
 // NewTradeService creates new TradeService instance.
+// Exposure tracker is optional, if it is set buys are skipped when the global exposure limit is reached.
 func NewTradeService(l *zap.Logger, pair entity.Pair, amount decimal.Decimal, pricer Pricer, detector Detector,
-	trader Trader, anomalyDetector AnomalyDetector) (*TradeService, error) {
-	w, err := NewWrappedWal()
+	trader Trader, anomalyDetector AnomalyDetector, exposure *ExposureTracker, walCfg WalConfig) (*TradeService, error) {
+	w, err := NewWrappedWal(walCfg)
 	if err != nil {
 		return nil, err
 	}
@@ -78,26 +184,56 @@ func NewTradeService(l *zap.Logger, pair entity.Pair, amount decimal.Decimal, pr
 	}
 
 	return &TradeService{
-		pair,
-		amount,
-		lastBuy.price,
-		decimal.Zero,
-		pricer,
-		detector,
-		trader,
-		anomalyDetector,
-		l, w,
-		errors.Is(err, ErrNoData),
+		id:              pair.String(),
+		pair:            pair,
+		amount:          amount,
+		lastBuyPrice:    lastBuy.price,
+		tradePart:       decimal.Zero,
+		bought:          lastBuy.position,
+		pricer:          pricer,
+		detector:        detector,
+		trader:          trader,
+		anomalyDetector: anomalyDetector,
+		l:               l,
+		wal:             w,
+		exposure:        exposure,
+		noTrades:        errors.Is(err, ErrNoData),
+		now:             time.Now,
 	}, nil
 }
 
 // Trade checks current price of asset and decides whether to buy, sell or do anything.
+// The cycle is traced with its orders if tracing is set up.
 func (t *TradeService) Trade() (*entity.TradeEvent, error) {
+	ctx, span := tracer.Start(context.Background(), "trade_cycle",
+		trace.WithAttributes(attribute.String("pair", t.pair.String())))
+	defer span.End()
+
+	tradeEvent, err := t.trade(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	if tradeEvent != nil {
+		span.SetAttributes(attribute.String("action", tradeEvent.Action.String()),
+			attribute.String("reason", string(tradeEvent.Reason)))
+	}
+
+	return tradeEvent, err
+}
+
+func (t *TradeService) trade(ctx context.Context) (*entity.TradeEvent, error) {
+	defer t.setPhase(PhaseIdle)
+
+	t.setPhase(PhasePricing)
 	price, err := t.pricer.GetPrice(t.pair)
 	if err != nil {
 		return nil, errors.Wrapf(err, "pricer failed for pair %s", t.pair.String())
 	}
+	t.priceFetchedAt = t.now()
+	t.lastPrice = price
 
+	t.setPhase(PhaseDetection)
 	act, err := t.detector.NeedAction(price)
 	if err != nil {
 		return nil, errors.Wrapf(err, "detector failed for pair %s", t.pair.String())
@@ -108,24 +244,41 @@ func (t *TradeService) Trade() (*entity.TradeEvent, error) {
 		return nil, nil
 	}
 
+	t.setPhase(PhaseExecution)
+	// position sold by the stop must be closed before any other order is placed for it
+	if tradeEvent, err := t.syncTrailingStop(); err != nil || tradeEvent != nil {
+		return tradeEvent, err
+	}
+
+	if t.dailyLoss != nil {
+		if t.bought.IsPositive() {
+			t.dailyLoss.Revalue(t.id, t.bought.Mul(price))
+		}
+		if t.dailyLoss.Halted() {
+			return t.flatten(ctx, price)
+		}
+	}
+
 	var tradeEvent *entity.TradeEvent
 	switch act {
 	case entity.ActionBuy:
-		tradeEvent, err = t.actBuy(price)
+		tradeEvent, err = t.actBuy(ctx, price)
 		if err != nil {
+			return nil, err
 		}
 
 		t.noTrades = false
 	case entity.ActionSell:
-		tradeEvent, err = t.actSell(price)
+		tradeEvent, err = t.actSell(ctx, price)
 		if err != nil {
+			return nil, err
 		}
 
 	case entity.ActionNull:
 		if price.LessThanOrEqual(t.lastBuyPrice) {
 			if isPercentDifferenceSignificant(price, t.lastBuyPrice, dcaPercentThresholdBuy) {
 				if t.tradePart.LessThan(decimal.NewFromInt(maxDcaTrades)) {
-					return t.actBuy(price)
+					return t.actBuy(ctx, price)
 				}
 			}
 		}
@@ -134,22 +287,175 @@ func (t *TradeService) Trade() (*entity.TradeEvent, error) {
 	return tradeEvent, nil
 }
 
+// Phase returns the step of the running trade cycle, it is safe to call while Trade is running.
+func (t *TradeService) Phase() Phase {
+	return Phase(t.phase.Load())
+}
+
+func (t *TradeService) setPhase(p Phase) {
+	t.phase.Store(int32(p))
+}
+
+// LastPrice returns price polled by the last trade cycle, zero before the first one.
+func (t *TradeService) LastPrice() decimal.Decimal {
+	return t.lastPrice
+}
+
+// Position returns amount of base asset bought by the current series, zero if there is no position.
+func (t *TradeService) Position() decimal.Decimal {
+	return t.bought
+}
+
+// InPosition returns true if the asset is bought and is waiting to be sold.
+func (t *TradeService) InPosition() bool {
+	return t.tradePart.IsPositive() || t.detector.LastAction() == entity.ActionBuy
+}
+
+// SetSizeJitter enables randomization of every buy amount by up to ±percent around the computed size
+// to make orders harder to detect. Source allows deterministic sizes in tests.
+func (t *TradeService) SetSizeJitter(percent decimal.Decimal, source rand.Source) {
+	t.sizeJitter = percent
+	t.rng = rand.New(source)
+}
+
+// SetQuoteReserve sets quote balance which is never spent, buys are skipped when balance above it is insufficient.
+func (t *TradeService) SetQuoteReserve(reserve decimal.Decimal) {
+	t.quoteReserve = reserve
+}
+
+// SetInitialBuyNotional sets exact quote amount of the first buy of every series,
+// DCA buys of the series are still derived from allocation.
+func (t *TradeService) SetInitialBuyNotional(notional decimal.Decimal) {
+	t.initialBuyNotional = notional
+}
+
+// SetDailyLoss enables the daily loss limit shared by all bots, once it is exceeded the position is sold
+// and the bot doesn't trade until the next day.
+func (t *TradeService) SetDailyLoss(tracker *DailyLossTracker) {
+	t.dailyLoss = tracker
+}
+
+// SetHalted pauses (or resumes) buys when trading on the pair is halted by exchange.
+// Sells are still attempted while trading is halted.
+func (t *TradeService) SetHalted(halted bool) {
+	t.halted.Store(halted)
+}
+
+// Halted returns true if buys are paused due to trading halt.
+func (t *TradeService) Halted() bool {
+	return t.halted.Load()
+}
+
+// Stop makes running and next trade cycles return ErrStopped instead of placing orders. Orders which are already
+// sent are still saved, so it is safe to call while a cycle hangs in an exchange request.
+func (t *TradeService) Stop() {
+	t.stopped.Store(true)
+}
+
 func (t *TradeService) Close() error {
 	return t.wal.Close()
 }
 
-func (t *TradeService) actBuy(price decimal.Decimal) (*entity.TradeEvent, error) {
+// SetInstanceID sets id of the instance isolated from other bots trading the same pair.
+// WAL directory of the instance is set by WalConfig, see WalDir.
+func (t *TradeService) SetInstanceID(instanceID string) {
+	t.id = entity.BotID(t.pair, instanceID)
+}
+
+// SetAmountRounding makes every buy amount rounded down to decimals, so bought amount matches orders exactly.
+func (t *TradeService) SetAmountRounding(decimals int32) {
+	t.roundAmount = true
+	t.amountDecimals = decimals
+}
+
+// SetMaxPriceAge sets max age of the price orders are placed at. Older price is fetched again right before
+// the order is sent, and the order is cancelled if the fresh price doesn't trigger it anymore.
+func (t *TradeService) SetMaxPriceAge(age time.Duration) {
+	t.maxPriceAge = age
+}
+
+// SetMaxSlippage enables check of best order book price right before market orders are sent,
+// the order is aborted if it would be filled worse than percent off the price it is decided at.
+func (t *TradeService) SetMaxSlippage(quoter Quoter, percent decimal.Decimal) {
+	t.quoter = quoter
+	t.maxSlippage = percent
+}
+
+// SetTrailingStop enables exchange-native trailing stop with callback percent, placed after every buy.
+func (t *TradeService) SetTrailingStop(stopper TrailingStopper, callbackPercent decimal.Decimal) {
+	t.stopper = stopper
+	t.trailingStop = callbackPercent
+}
+
+func (t *TradeService) actBuy(ctx context.Context, price decimal.Decimal) (*entity.TradeEvent, error) {
 	if !isPercentDifferenceSignificant(price, t.lastBuyPrice, dcaPercentThresholdBuy) {
 		return nil, nil
 	}
 
+	if t.halted.Load() {
+		t.l.Info("skip buy, trading is halted", zap.String("pair", t.pair.String()))
+		return nil, nil
+	}
+
 	if t.tradePart.GreaterThanOrEqual(decimal.NewFromInt(maxDcaTrades)) {
 		fmt.Println("skip buy, insufficient balance")
 	}
 
-	amount := t.amount.Div(decimal.NewFromInt(maxDcaTrades))
-	if err := t.trader.Buy(amount); err != nil {
-		return nil, errors.Wrapf(err, "trader buy failed for pair %s", t.pair.String())
+	amount := t.buyAmount(price)
+	if !amount.IsPositive() {
+		t.l.Info("skip buy, allocation is spent", zap.String("pair", t.pair.String()))
+		return nil, nil
+	}
+	notional := amount.Mul(price)
+	if err := t.checkBalance(notional); err != nil {
+		if errors.Is(err, ErrInsufficientBalance) {
+			t.l.Info("skip buy", zap.String("pair", t.pair.String()), zap.Error(err))
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if t.exposure != nil && !t.exposure.Reserve(t.id, notional) {
+		t.l.Info("skip buy, global exposure limit reached",
+			zap.String("pair", t.pair.String()),
+			zap.String("notional", notional.String()),
+			zap.String("deployed", t.exposure.Deployed().String()),
+			zap.Uint64("skipped", t.exposure.Skipped()))
+		return nil, nil
+	}
+
+	price, ok, err := t.refreshPrice(price, t.buyTriggered)
+	if err == nil && ok {
+		ok, err = t.checkSlippage(entity.ActionBuy, price)
+	}
+	if err != nil || !ok {
+		if t.exposure != nil {
+			t.exposure.Release(t.id, notional)
+		}
+		return nil, err
+	}
+
+	if t.stopped.Load() {
+		if t.exposure != nil {
+			t.exposure.Release(t.id, notional)
+		}
+		return nil, errors.Wrapf(ErrStopped, "buy is not sent for pair %s", t.pair.String())
+	}
+
+	correlationID := newCorrelationID()
+	if err := t.placeOrder(ctx, entity.ActionBuy, amount); err != nil {
+		if t.exposure != nil {
+			t.exposure.Release(t.id, notional)
+		}
+		if errors.Is(err, ErrBuyBlocked) {
+			t.l.Warn("skip buy", zap.String("pair", t.pair.String()), zap.Error(err))
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "trader buy failed for pair %s, id %s", t.pair.String(), correlationID)
+	}
+
+	if t.dailyLoss != nil {
+		t.dailyLoss.Bought(t.id, amount.Mul(price))
 	}
 
 	if err := t.wal.Write("lastamount", amount); err != nil {
@@ -166,26 +472,68 @@ func (t *TradeService) actBuy(price decimal.Decimal) (*entity.TradeEvent, error)
 		t.lastBuyPrice = price
 	}
 
+	reason := entity.TradeReasonEntry
+	if t.tradePart.IsPositive() {
+		reason = entity.TradeReasonDCA
+	}
 	tradeEvent := &entity.TradeEvent{
-		Action: entity.ActionBuy,
-		Amount: amount,
-		Pair:   t.pair,
-		Price:  price,
+		Action:        entity.ActionBuy,
+		Reason:        reason,
+		Amount:        amount,
+		Pair:          t.pair,
+		Price:         price,
+		CorrelationID: correlationID,
 	}
 
 	if t.tradePart.GreaterThan(decimal.NewFromInt(0)) {
-		fmt.Println("DCA buy trade part", t.tradePart.Add(decimal.NewFromInt(1)),
-			"price", price,
-			"first DCA buy price", t.lastBuyPrice,
-		)
+		t.l.Info("DCA buy",
+			zap.String("pair", t.pair.String()),
+			zap.String("trade part", t.tradePart.Add(decimal.NewFromInt(1)).String()),
+			zap.String("price", price.String()),
+			zap.String("first DCA buy price", t.lastBuyPrice.String()))
 	}
 
 	t.tradePart = t.tradePart.Add(decimal.NewFromInt(1))
+	t.bought = t.bought.Add(amount)
+	if err := t.wal.Write("position", t.bought); err != nil {
+		return nil, errors.Wrapf(err, "failed to write position for pair %s", t.pair.String())
+	}
+
+	if t.stopper != nil {
+		// position is already opened, failed stop must not fail the trade
+		if err := t.stopper.SetTrailingStop(t.bought, t.trailingStop); err != nil {
+			t.l.Error("failed to place trailing stop", zap.String("pair", t.pair.String()), zap.Error(err))
+		}
+	}
 
 	return tradeEvent, nil
 }
 
-func (t *TradeService) actSell(price decimal.Decimal) (*entity.TradeEvent, error) {
+// buyAmount returns amount of a single DCA buy, randomized by size jitter and rounded if these are set.
+// The first buy of series is sized by initial buy notional if it is set. Bought amount never exceeds allocation.
+func (t *TradeService) buyAmount(price decimal.Decimal) decimal.Decimal {
+	var amount decimal.Decimal
+	if t.initialBuyNotional.IsPositive() && t.tradePart.IsZero() && t.bought.IsZero() {
+		// exact notional is requested, so it is not randomized
+		amount = t.initialBuyNotional.Div(price)
+	} else {
+		amount = t.amount.Div(decimal.NewFromInt(maxDcaTrades))
+		if t.sizeJitter.IsPositive() && t.rng != nil {
+			// uniformly distributed in [-1, 1)
+			factor := decimal.NewFromFloat(t.rng.Float64()*2 - 1)
+			amount = amount.Add(amount.Mul(t.sizeJitter).Div(decimal.NewFromInt(100)).Mul(factor))
+		}
+	}
+
+	amount = decimal.Min(amount, t.amount.Sub(t.bought))
+	if t.roundAmount {
+		amount = amount.RoundFloor(t.amountDecimals)
+	}
+
+	return amount
+}
+
+func (t *TradeService) actSell(ctx context.Context, price decimal.Decimal) (*entity.TradeEvent, error) {
 	if t.lastBuyPrice.IsZero() {
 		return nil, nil
 	}
@@ -196,31 +544,334 @@ func (t *TradeService) actSell(price decimal.Decimal) (*entity.TradeEvent, error
 
 	if price.LessThanOrEqual(t.lastBuyPrice) {
 		if t.tradePart.LessThan(decimal.NewFromInt(maxDcaTrades)) {
-			return t.actBuy(price)
+			return t.actBuy(ctx, price)
+		}
+
+	}
+
+	price, ok, err := t.refreshPrice(price, t.sellTriggered)
+	if err == nil && ok {
+		ok, err = t.checkSlippage(entity.ActionSell, price)
+	}
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	// price below the buy price can trigger the sell only when all DCA buys are done
+	reason := entity.TradeReasonTakeProfit
+	if price.LessThanOrEqual(t.lastBuyPrice) {
+		reason = entity.TradeReasonStopLoss
+	}
+
+	return t.sellPosition(ctx, price, reason)
+}
+
+// flatten sells the position after the daily loss limit is exceeded, nothing else is traded until the next day.
+func (t *TradeService) flatten(ctx context.Context, price decimal.Decimal) (*entity.TradeEvent, error) {
+	if !t.bought.IsPositive() {
+		t.l.Debug("skip trading, daily loss limit is exceeded", zap.String("pair", t.pair.String()))
+		return nil, nil
+	}
+
+	t.l.Warn("daily loss limit is exceeded, sell the position",
+		zap.String("pair", t.pair.String()),
+		zap.String("daily pnl", t.dailyLoss.PnL().String()),
+		zap.String("amount", t.bought.String()))
+
+	return t.sellPosition(ctx, price, entity.TradeReasonDailyLoss)
+}
+
+// sellPosition sells the whole position at price and closes the series.
+func (t *TradeService) sellPosition(ctx context.Context, price decimal.Decimal, reason entity.TradeReason) (*entity.TradeEvent, error) {
+	if t.stopped.Load() {
+		return nil, errors.Wrapf(ErrStopped, "sell is not sent for pair %s", t.pair.String())
+	}
+
+	if t.stopper != nil {
+		// stop order locks the asset, so it must be canceled before selling
+		if err := t.stopper.CancelTrailingStop(); err != nil {
+			return nil, errors.Wrapf(err, "failed to cancel trailing stop for pair %s", t.pair.String())
 		}
+	}
+
+	amount := t.bought
+	correlationID := newCorrelationID()
+	if err := t.placeOrder(ctx, entity.ActionSell, amount); err != nil {
+		return nil, errors.Wrapf(err, "trader sell failed for pair %s, id %s", t.pair.String(), correlationID)
+	}
+
+	if err := t.closePosition(price); err != nil {
+		return nil, err
+	}
+
+	tradeEvent := &entity.TradeEvent{
+		Action:        entity.ActionSell,
+		Reason:        reason,
+		Amount:        amount,
+		Pair:          t.pair,
+		Price:         price,
+		CorrelationID: correlationID,
+	}
+
+	return tradeEvent, nil
+}
+
+// syncTrailingStop closes the position if it is sold by the trailing stop on exchange,
+// the sell is returned as a trade event. Returns nil if there is no filled stop.
+func (t *TradeService) syncTrailingStop() (*entity.TradeEvent, error) {
+	if t.stopper == nil || !t.bought.IsPositive() {
+		return nil, nil
+	}
+
+	amount, price, err := t.stopper.TrailingStopFill()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to check trailing stop for pair %s", t.pair.String())
+	}
+	if !amount.IsPositive() {
+		return nil, nil
+	}
 
+	// the stop id is reused by every series, so the fill is trusted only if the position is gone from balance
+	balance, err := balanceDetailed(t.trader, t.pair.From)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get %s balance for pair %s", t.pair.From, t.pair.String())
+	}
+	if !balance.Total().LessThan(t.bought) || !positionMismatch(t.bought, balance.Total()) {
+		t.l.Warn("trailing stop is filled, but position is still on balance, the fill is ignored",
+			zap.String("pair", t.pair.String()),
+			zap.String("position", t.bought.String()),
+			zap.String("balance", balance.Total().String()))
+		return nil, nil
 	}
 
-	amount := t.amount.Div(decimal.NewFromInt(maxDcaTrades)).Mul(t.tradePart)
-	if err := t.trader.Sell(amount); err != nil {
-		return nil, errors.Wrapf(err, "trader sell failed for pair %s", t.pair)
+	t.l.Info("position is sold by trailing stop",
+		zap.String("pair", t.pair.String()),
+		zap.String("amount", amount.String()),
+		zap.String("price", price.String()))
+	if err := t.closePosition(price); err != nil {
+		return nil, err
 	}
 
+	return &entity.TradeEvent{
+		Action:        entity.ActionSell,
+		Reason:        entity.TradeReasonTrailingStop,
+		Amount:        amount,
+		Pair:          t.pair,
+		Price:         price,
+		CorrelationID: newCorrelationID(),
+	}, nil
+}
+
+// closePosition resets the series after the whole position is sold at price.
+func (t *TradeService) closePosition(price decimal.Decimal) error {
+	if t.dailyLoss != nil {
+		t.dailyLoss.Sold(t.id, t.bought.Mul(price))
+	}
 	t.tradePart = decimal.Zero
+	t.bought = decimal.Zero
+	if t.exposure != nil {
+		t.exposure.Reset(t.id)
+	}
+	if err := t.wal.Write("position", decimal.Zero); err != nil {
+		return errors.Wrapf(err, "failed to write position for pair %s", t.pair.String())
+	}
 
 	if err := t.wal.Write("lastbuy", price); err != nil {
-		return nil, errors.Wrapf(err, "failed to write last buy price for pair %s", t.pair.String())
+		return errors.Wrapf(err, "failed to write last buy price for pair %s", t.pair.String())
 	}
 	t.lastBuyPrice = price
 
-	tradeEvent := &entity.TradeEvent{
-		Action: entity.ActionSell,
-		Amount: amount,
-		Pair:   t.pair,
-		Price:  price,
+	return nil
+}
+
+// ReconcilePosition compares the tracked position with base balance on exchange, a significant mismatch means
+// fills the bot doesn't know about (e.g. it crashed after a fill but before the WAL write) or manual trades.
+// The mismatch is logged, and if adopt is set the balance is taken as the position. Current price is taken
+// as the entry price of adopted position if the entry price is unknown.
+func (t *TradeService) ReconcilePosition(balance, price decimal.Decimal, adopt bool) error {
+	if !positionMismatch(t.bought, balance) {
+		return nil
 	}
 
-	return tradeEvent, nil
+	t.l.Warn("tracked position doesn't match exchange balance",
+		zap.String("pair", t.pair.String()),
+		zap.String("tracked", t.bought.String()),
+		zap.String("exchange", balance.String()),
+		zap.Bool("adopt", adopt))
+	if !adopt {
+		return nil
+	}
+
+	if err := t.wal.Write("position", balance); err != nil {
+		return errors.Wrapf(err, "failed to write position for pair %s", t.pair.String())
+	}
+	t.bought = balance
+	if balance.IsZero() {
+		t.tradePart = decimal.Zero
+		return nil
+	}
+
+	if t.tradePart.IsZero() {
+		t.tradePart = decimal.NewFromInt(1)
+	}
+	if t.lastBuyPrice.IsZero() {
+		if err := t.wal.Write("lastbuy", price); err != nil {
+			return errors.Wrapf(err, "failed to write last buy price for pair %s", t.pair.String())
+		}
+		t.lastBuyPrice = price
+	}
+
+	return nil
+}
+
+// positionMismatch checks whether tracked position and exchange balance differ by more than
+// positionMismatchPercent, amounts below order precision are ignored.
+func positionMismatch(tracked, balance decimal.Decimal) bool {
+	diff := tracked.Sub(balance).Abs()
+	if diff.RoundFloor(4).IsZero() {
+		return false
+	}
+
+	return diff.Mul(decimal.NewFromInt(100)).GreaterThan(decimal.Max(tracked, balance).Mul(decimal.NewFromInt(positionMismatchPercent)))
+}
+
+// refreshPrice fetches price again if it is older than max price age, and checks whether the fresh price
+// still triggers the order. Returns false if the order must be cancelled before sending.
+func (t *TradeService) refreshPrice(price decimal.Decimal, triggered func(decimal.Decimal) bool) (decimal.Decimal, bool, error) {
+	if t.maxPriceAge <= 0 || t.now().Sub(t.priceFetchedAt) <= t.maxPriceAge {
+		return price, true, nil
+	}
+
+	fresh, err := t.pricer.GetPrice(t.pair)
+	if err != nil {
+		return decimal.Zero, false, errors.Wrapf(err, "pricer failed for pair %s", t.pair.String())
+	}
+	t.priceFetchedAt = t.now()
+
+	if !triggered(fresh) {
+		t.l.Info("order is cancelled before sending, fresh price doesn't trigger it",
+			zap.String("pair", t.pair.String()),
+			zap.String("stale price", price.String()),
+			zap.String("fresh price", fresh.String()))
+		return decimal.Zero, false, nil
+	}
+
+	return fresh, true, nil
+}
+
+// checkSlippage compares price the order is decided at with the best price of order book it would be filled at.
+// Returns false if projected slippage exceeds max slippage and the order must be aborted.
+func (t *TradeService) checkSlippage(action entity.Action, price decimal.Decimal) (bool, error) {
+	if t.quoter == nil || !t.maxSlippage.IsPositive() {
+		return true, nil
+	}
+
+	bid, ask, err := t.quoter.BestPrices(t.pair)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get best prices for pair %s", t.pair.String())
+	}
+
+	// buys are filled at ask, sells at bid
+	loss := ask.Sub(price)
+	if action == entity.ActionSell {
+		loss = price.Sub(bid)
+	}
+	slippage := loss.Div(price).Mul(decimal.NewFromInt(100))
+	if slippage.GreaterThan(t.maxSlippage) {
+		t.l.Warn("order is aborted, projected slippage is too high",
+			zap.String("pair", t.pair.String()),
+			zap.String("action", action.String()),
+			zap.String("price", price.String()),
+			zap.String("bid", bid.String()),
+			zap.String("ask", ask.String()),
+			zap.String("slippage percent", slippage.StringFixed(3)))
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// buyTriggered checks whether price triggers the next buy: the first buy needs a price different enough
+// from the last trade price, DCA buys need a significant drop below the first buy price.
+func (t *TradeService) buyTriggered(price decimal.Decimal) bool {
+	if t.tradePart.IsPositive() && price.GreaterThan(t.lastBuyPrice) {
+		return false
+	}
+
+	return isPercentDifferenceSignificant(price, t.lastBuyPrice, dcaPercentThresholdBuy)
+}
+
+// sellTriggered checks whether price is significantly different from the buy price to sell: it is above it,
+// or below it when all DCA buys are done.
+func (t *TradeService) sellTriggered(price decimal.Decimal) bool {
+	if price.LessThanOrEqual(t.lastBuyPrice) && t.tradePart.LessThan(decimal.NewFromInt(maxDcaTrades)) {
+		return false
+	}
+
+	return isPercentDifferenceSignificant(price, t.lastBuyPrice, dcaPercentThresholdSell)
+}
+
+// checkBalance returns ErrInsufficientBalance with the shortfall if free quote balance above
+// the quote reserve doesn't cover required notional.
+// Buys are sized from free balance only, funds locked in open orders are reported for diagnostics.
+func (t *TradeService) checkBalance(required decimal.Decimal) error {
+	balance, err := balanceDetailed(t.trader, t.pair.To)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get %s balance for pair %s", t.pair.To, t.pair.String())
+	}
+
+	available := balance.Free.Sub(t.quoteReserve)
+	if available.LessThan(required) {
+		return errors.Wrapf(ErrInsufficientBalance, "required %s %s, available %s (reserve %s, locked %s), shortfall %s",
+			required.String(), t.pair.To, available.String(), t.quoteReserve.String(), balance.Locked.String(),
+			required.Sub(available).String())
+	}
+
+	return nil
+}
+
+// balanceDetailed returns detailed balance of currency if trader reports it,
+// otherwise the whole balance returned by trader is considered free.
+func balanceDetailed(trader Trader, currency string) (entity.Balance, error) {
+	if b, ok := trader.(DetailedBalancer); ok {
+		return b.GetBalanceDetailed(currency)
+	}
+
+	free, err := trader.GetBalance(currency)
+	if err != nil {
+		return entity.Balance{}, err
+	}
+
+	return entity.Balance{Free: free}, nil
+}
+
+// placeOrder sends order of action for amount to the exchange within span of the trade cycle.
+func (t *TradeService) placeOrder(ctx context.Context, action entity.Action, amount decimal.Decimal) error {
+	_, span := tracer.Start(ctx, "place_order", trace.WithAttributes(
+		attribute.String("pair", t.pair.String()),
+		attribute.String("action", action.String()),
+		attribute.String("amount", amount.String())))
+	defer span.End()
+
+	var err error
+	if action == entity.ActionBuy {
+		err = t.trader.Buy(amount)
+	} else {
+		err = t.trader.Sell(amount)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return err
+}
+
+// newCorrelationID returns random id of a trade operation.
+func newCorrelationID() string {
+	b := make([]byte, 8)
+	_, _ = crand.Read(b)
+	return hex.EncodeToString(b)
 }
 
 func isPercentDifferenceSignificant(a, b decimal.Decimal, dcaPercentThreshold float64) bool {
@@ -1,15 +1,24 @@
 package services
 
 import (
+	"context"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"github.com/vadiminshakov/marti/entity"
 	anomalymock "github.com/vadiminshakov/marti/services/anomalydetector/mock"
 	detectormock "github.com/vadiminshakov/marti/services/detector/mock"
 	tradermock "github.com/vadiminshakov/marti/services/trader/mock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"go.uber.org/zap"
+	"math/rand"
+	"os"
 	"testing"
+	"time"
 )
 
 type pricemock struct {
@@ -29,6 +38,7 @@ func TestTrade(t *testing.T) {
 	trader := tradermock.NewTrader(t)
 	trader.On("Buy", mock.Anything).Return(nil)
 	trader.On("Sell", mock.Anything).Return(nil)
+	trader.On("GetBalance", "USD").Return(decimal.NewFromInt(100), nil)
 
 	detector := detectormock.NewDetector(t)
 	detector.On("NeedAction", decimal.NewFromInt(1)).Return(entity.ActionBuy, nil)
@@ -44,8 +54,9 @@ func TestTrade(t *testing.T) {
 
 	l, err := zap.NewProduction()
 	assert.NoError(t, err)
-	ts, err := NewTradeService(l, pair, amount, pricer, detector, trader, anomalyDetector)
+	ts, err := NewTradeService(l, pair, amount, pricer, detector, trader, anomalyDetector, nil, WalConfig{})
 	assert.NoError(t, err)
+	defer ts.Close()
 
 	event, err := ts.Trade()
 	assert.NoError(t, err)
@@ -70,3 +81,703 @@ func TestTrade(t *testing.T) {
 	trader.AssertNumberOfCalls(t, "Buy", 1)
 	trader.AssertNumberOfCalls(t, "Sell", 1)
 }
+
+func TestTradeSkipsBuyOnInsufficientBalance(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	pair := entity.Pair{From: "BTC", To: "USD"}
+
+	trader := tradermock.NewTrader(t)
+	trader.On("GetBalance", "USD").Return(decimal.NewFromFloat(0.1), nil)
+
+	detector := detectormock.NewDetector(t)
+	detector.On("NeedAction", decimal.NewFromInt(1)).Return(entity.ActionBuy, nil)
+
+	anomalyDetector := anomalymock.NewAnomalyDetector(t)
+	anomalyDetector.On("IsAnomaly", mock.Anything).Return(false, nil)
+
+	ts, err := NewTradeService(zap.NewNop(), pair, decimal.NewFromInt(1), &pricemock{}, detector, trader, anomalyDetector, nil, WalConfig{})
+	assert.NoError(t, err)
+	defer ts.Close()
+
+	err = ts.checkBalance(decimal.NewFromFloat(0.2))
+	assert.ErrorIs(t, err, ErrInsufficientBalance)
+	assert.Contains(t, err.Error(), "shortfall 0.1")
+
+	event, err := ts.Trade()
+	assert.NoError(t, err)
+	assert.Nil(t, event)
+	assert.True(t, ts.tradePart.IsZero())
+
+	trader.AssertNotCalled(t, "Buy", mock.Anything)
+}
+
+func TestTradeHalted(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	pair := entity.Pair{From: "BTC", To: "USD"}
+
+	trader := tradermock.NewTrader(t)
+	trader.On("GetBalance", "USD").Return(decimal.NewFromInt(100), nil)
+	trader.On("Buy", mock.Anything).Return(nil)
+	trader.On("Sell", mock.Anything).Return(nil)
+
+	detector := detectormock.NewDetector(t)
+	detector.On("NeedAction", decimal.NewFromInt(1)).Return(entity.ActionBuy, nil)
+	detector.On("NeedAction", decimal.NewFromInt(2)).Return(entity.ActionBuy, nil)
+	detector.On("NeedAction", decimal.NewFromInt(3)).Return(entity.ActionSell, nil)
+
+	anomalyDetector := anomalymock.NewAnomalyDetector(t)
+	anomalyDetector.On("IsAnomaly", mock.Anything).Return(false, nil)
+
+	ts, err := NewTradeService(zap.NewNop(), pair, decimal.NewFromInt(1), &pricemock{}, detector, trader, anomalyDetector, nil, WalConfig{})
+	assert.NoError(t, err)
+	defer ts.Close()
+
+	// trading is halted, buy is paused
+	ts.SetHalted(true)
+	event, err := ts.Trade()
+	assert.NoError(t, err)
+	assert.Nil(t, event)
+	trader.AssertNotCalled(t, "Buy", mock.Anything)
+
+	// trading is resumed
+	ts.SetHalted(false)
+	event, err = ts.Trade()
+	assert.NoError(t, err)
+	assert.Equal(t, entity.ActionBuy, event.Action)
+
+	// halted again, exit is still attempted
+	ts.SetHalted(true)
+	event, err = ts.Trade()
+	assert.NoError(t, err)
+	assert.Equal(t, entity.ActionSell, event.Action)
+
+	trader.AssertNumberOfCalls(t, "Buy", 1)
+	trader.AssertNumberOfCalls(t, "Sell", 1)
+}
+
+func TestBuyAmountJitter(t *testing.T) {
+	price := decimal.NewFromInt(100)
+	newService := func(seed int64) *TradeService {
+		ts := &TradeService{amount: decimal.NewFromInt(10)}
+		ts.SetSizeJitter(decimal.NewFromInt(10), rand.NewSource(seed))
+		return ts
+	}
+
+	// 10 / maxDcaTrades = 2, so sizes must be within [1.8, 2.2]
+	ts := newService(1)
+	minAmount, maxAmount := decimal.NewFromFloat(1.8), decimal.NewFromFloat(2.2)
+	sizes := make(map[string]struct{})
+	for i := 0; i < 1000; i++ {
+		amount := ts.buyAmount(price)
+		assert.True(t, amount.GreaterThanOrEqual(minAmount) && amount.LessThanOrEqual(maxAmount), "amount %s is out of jitter bounds", amount)
+		sizes[amount.String()] = struct{}{}
+	}
+	assert.Greater(t, len(sizes), 900, "sizes must be randomized")
+
+	// the same seed gives the same sizes
+	a, b := newService(42), newService(42)
+	for i := 0; i < 10; i++ {
+		assert.True(t, a.buyAmount(price).Equal(b.buyAmount(price)))
+	}
+
+	// bought amount never exceeds allocation
+	for seed := int64(0); seed < 100; seed++ {
+		ts := newService(seed)
+		for i := 0; i < maxDcaTrades+1; i++ {
+			ts.bought = ts.bought.Add(ts.buyAmount(price))
+		}
+		assert.True(t, ts.bought.LessThanOrEqual(ts.amount), "bought %s exceeds allocation", ts.bought)
+	}
+}
+
+func TestBuyAmountRounding(t *testing.T) {
+	price := decimal.NewFromInt(100)
+	ts := &TradeService{amount: decimal.RequireFromString("0.123456789")}
+	ts.SetAmountRounding(4)
+
+	// 0.123456789 / 5 = 0.0246913578 is rounded down
+	assert.Equal(t, "0.0246", ts.buyAmount(price).String())
+
+	// initial and DCA buys are rounded alike, the last one is capped by the rest of allocation
+	for i := 0; i < maxDcaTrades; i++ {
+		amount := ts.buyAmount(price)
+		assert.True(t, amount.Equal(amount.RoundFloor(4)), "amount %s is not rounded", amount)
+		ts.bought = ts.bought.Add(amount)
+	}
+	assert.Equal(t, "0.123", ts.bought.String())
+
+	ts.SetSizeJitter(decimal.NewFromInt(10), rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		amount := ts.buyAmount(price)
+		assert.True(t, amount.Equal(amount.RoundFloor(4)), "jittered amount %s is not rounded", amount)
+	}
+}
+
+func TestInitialBuyNotional(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	pair := entity.Pair{From: "BTC", To: "USD"}
+	pricer := &dcaPricer{prices: []int64{100, 90}}
+	trader := &quoteTrader{pricer: pricer, balance: decimal.NewFromInt(10000)}
+
+	detector := detectormock.NewDetector(t)
+	detector.On("NeedAction", decimal.NewFromInt(100)).Return(entity.ActionBuy, nil)
+	detector.On("NeedAction", mock.Anything).Return(entity.ActionNull, nil)
+
+	anomalyDetector := anomalymock.NewAnomalyDetector(t)
+	anomalyDetector.On("IsAnomaly", mock.Anything).Return(false, nil)
+
+	ts, err := NewTradeService(zap.NewNop(), pair, decimal.NewFromInt(50), pricer, detector, trader, anomalyDetector, nil, WalConfig{})
+	require.NoError(t, err)
+	defer ts.Close()
+	ts.SetInitialBuyNotional(decimal.NewFromInt(250))
+
+	// first buy is sized by exact notional, 250 / 100
+	te, err := ts.Trade()
+	require.NoError(t, err)
+	require.NotNil(t, te)
+	assert.Equal(t, "2.5", te.Amount.String())
+
+	// DCA buy of the series is derived from allocation, 50 / maxDcaTrades
+	te, err = ts.Trade()
+	require.NoError(t, err)
+	require.NotNil(t, te)
+	assert.Equal(t, "10", te.Amount.String())
+}
+
+// quoteTrader spends quote balance on buys.
+type quoteTrader struct {
+	pricer  *dcaPricer
+	balance decimal.Decimal
+	buys    int
+	sells   int
+}
+
+func (t *quoteTrader) Buy(amount decimal.Decimal) error {
+	t.balance = t.balance.Sub(amount.Mul(t.pricer.current()))
+	t.buys++
+	return nil
+}
+
+func (t *quoteTrader) Sell(_ decimal.Decimal) error {
+	t.sells++
+	return nil
+}
+
+func (t *quoteTrader) GetBalance(_ string) (decimal.Decimal, error) {
+	return t.balance, nil
+}
+
+// dcaPricer returns falling prices.
+type dcaPricer struct {
+	prices []int64
+	n      int
+}
+
+func (p *dcaPricer) GetPrice(_ entity.Pair) (decimal.Decimal, error) {
+	p.n++
+	return p.current(), nil
+}
+
+func (p *dcaPricer) current() decimal.Decimal {
+	return decimal.NewFromInt(p.prices[p.n-1])
+}
+
+func TestTradeKeepsQuoteReserve(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	pair := entity.Pair{From: "BTC", To: "USD"}
+	pricer := &dcaPricer{prices: []int64{100, 95, 90, 85, 80}}
+	trader := &quoteTrader{pricer: pricer, balance: decimal.NewFromInt(600)}
+
+	detector := detectormock.NewDetector(t)
+	detector.On("NeedAction", decimal.NewFromInt(100)).Return(entity.ActionBuy, nil)
+	detector.On("NeedAction", mock.Anything).Return(entity.ActionNull, nil)
+
+	anomalyDetector := anomalymock.NewAnomalyDetector(t)
+	anomalyDetector.On("IsAnomaly", mock.Anything).Return(false, nil)
+
+	// every DCA buy is 2 BTC
+	ts, err := NewTradeService(zap.NewNop(), pair, decimal.NewFromInt(10), pricer, detector, trader, anomalyDetector, nil, WalConfig{})
+	assert.NoError(t, err)
+	defer ts.Close()
+	reserve := decimal.NewFromInt(200)
+	ts.SetQuoteReserve(reserve)
+
+	for range pricer.prices {
+		_, err := ts.Trade()
+		assert.NoError(t, err)
+	}
+
+	// 600 - 2*100 = 400, 400 - 2*95 = 210, then 210 - 2*90 would break the reserve
+	assert.Equal(t, 2, trader.buys)
+	assert.True(t, trader.balance.GreaterThanOrEqual(reserve), "balance %s is below reserve", trader.balance)
+}
+
+func TestTradeEventReasons(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	pair := entity.Pair{From: "BTC", To: "USD"}
+	pricer := &dcaPricer{prices: []int64{100, 95, 110}}
+	trader := &quoteTrader{pricer: pricer, balance: decimal.NewFromInt(1000)}
+
+	detector := detectormock.NewDetector(t)
+	detector.On("NeedAction", decimal.NewFromInt(100)).Return(entity.ActionBuy, nil)
+	detector.On("NeedAction", decimal.NewFromInt(95)).Return(entity.ActionNull, nil)
+	detector.On("NeedAction", decimal.NewFromInt(110)).Return(entity.ActionSell, nil)
+
+	anomalyDetector := anomalymock.NewAnomalyDetector(t)
+	anomalyDetector.On("IsAnomaly", mock.Anything).Return(false, nil)
+
+	ts, err := NewTradeService(zap.NewNop(), pair, decimal.NewFromInt(5), pricer, detector, trader, anomalyDetector, nil, WalConfig{})
+	assert.NoError(t, err)
+	defer ts.Close()
+
+	ids := make(map[string]struct{})
+	for _, expected := range []struct {
+		action entity.Action
+		reason entity.TradeReason
+	}{
+		{entity.ActionBuy, entity.TradeReasonEntry},
+		{entity.ActionBuy, entity.TradeReasonDCA},
+		{entity.ActionSell, entity.TradeReasonTakeProfit},
+	} {
+		event, err := ts.Trade()
+		assert.NoError(t, err)
+		assert.Equal(t, expected.action, event.Action)
+		assert.Equal(t, expected.reason, event.Reason)
+		assert.Len(t, event.CorrelationID, 16)
+		assert.Contains(t, event.String(), event.CorrelationID)
+		ids[event.CorrelationID] = struct{}{}
+	}
+	assert.Len(t, ids, 3, "every trade must have its own correlation id")
+}
+
+func TestTradeStopLossReason(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	pair := entity.Pair{From: "BTC", To: "USD"}
+	pricer := &dcaPricer{prices: []int64{100, 99, 98, 97, 96, 90}}
+	trader := &quoteTrader{pricer: pricer, balance: decimal.NewFromInt(1000)}
+
+	detector := detectormock.NewDetector(t)
+	detector.On("NeedAction", decimal.NewFromInt(100)).Return(entity.ActionBuy, nil)
+	detector.On("NeedAction", decimal.NewFromInt(90)).Return(entity.ActionSell, nil)
+	detector.On("NeedAction", mock.Anything).Return(entity.ActionNull, nil)
+
+	anomalyDetector := anomalymock.NewAnomalyDetector(t)
+	anomalyDetector.On("IsAnomaly", mock.Anything).Return(false, nil)
+
+	ts, err := NewTradeService(zap.NewNop(), pair, decimal.NewFromInt(5), pricer, detector, trader, anomalyDetector, nil, WalConfig{})
+	require.NoError(t, err)
+	defer ts.Close()
+
+	var event *entity.TradeEvent
+	for range pricer.prices {
+		event, err = ts.Trade()
+		require.NoError(t, err)
+	}
+
+	// all DCA buys are done, so the position is sold below the buy price
+	require.Equal(t, maxDcaTrades, trader.buys)
+	require.Equal(t, entity.ActionSell, event.Action)
+	require.Equal(t, entity.TradeReasonStopLoss, event.Reason)
+	require.Equal(t, "90", event.Price.String())
+}
+
+// recordingStopper records trailing stop calls in order.
+type recordingStopper struct {
+	calls []string
+	stops []decimal.Decimal
+
+	filled    decimal.Decimal
+	fillPrice decimal.Decimal
+}
+
+func (s *recordingStopper) SetTrailingStop(amount, _ decimal.Decimal) error {
+	s.calls = append(s.calls, "set")
+	s.stops = append(s.stops, amount)
+	return nil
+}
+
+func (s *recordingStopper) CancelTrailingStop() error {
+	s.calls = append(s.calls, "cancel")
+	return nil
+}
+
+func (s *recordingStopper) TrailingStopFill() (decimal.Decimal, decimal.Decimal, error) {
+	return s.filled, s.fillPrice, nil
+}
+
+func TestTradeTrailingStop(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	pair := entity.Pair{From: "BTC", To: "USD"}
+	pricer := &dcaPricer{prices: []int64{100, 95, 110}}
+	trader := &quoteTrader{pricer: pricer, balance: decimal.NewFromInt(1000)}
+
+	detector := detectormock.NewDetector(t)
+	detector.On("NeedAction", decimal.NewFromInt(100)).Return(entity.ActionBuy, nil)
+	detector.On("NeedAction", decimal.NewFromInt(95)).Return(entity.ActionNull, nil)
+	detector.On("NeedAction", decimal.NewFromInt(110)).Return(entity.ActionSell, nil)
+
+	anomalyDetector := anomalymock.NewAnomalyDetector(t)
+	anomalyDetector.On("IsAnomaly", mock.Anything).Return(false, nil)
+
+	ts, err := NewTradeService(zap.NewNop(), pair, decimal.NewFromInt(5), pricer, detector, trader, anomalyDetector, nil, WalConfig{})
+	assert.NoError(t, err)
+	defer ts.Close()
+	stopper := &recordingStopper{}
+	ts.SetTrailingStop(stopper, decimal.NewFromInt(2))
+
+	for range pricer.prices {
+		_, err := ts.Trade()
+		assert.NoError(t, err)
+	}
+
+	// stop follows the whole position and is removed before take profit
+	assert.Equal(t, []string{"set", "set", "cancel"}, stopper.calls)
+	assert.Equal(t, []string{"1", "2"}, []string{stopper.stops[0].String(), stopper.stops[1].String()})
+}
+
+// baseTrader reports base balance separately from quote balance.
+type baseTrader struct {
+	quoteTrader
+	base decimal.Decimal
+}
+
+func (t *baseTrader) GetBalance(currency string) (decimal.Decimal, error) {
+	if currency == "BTC" {
+		return t.base, nil
+	}
+	return t.balance, nil
+}
+
+func TestTradeTrailingStopFilled(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	pair := entity.Pair{From: "BTC", To: "USD"}
+	pricer := &dcaPricer{prices: []int64{100, 98, 99}}
+	trader := &baseTrader{quoteTrader: quoteTrader{pricer: pricer, balance: decimal.NewFromInt(1000)}}
+
+	detector := detectormock.NewDetector(t)
+	detector.On("NeedAction", decimal.NewFromInt(100)).Return(entity.ActionBuy, nil)
+	detector.On("NeedAction", decimal.NewFromInt(98)).Return(entity.ActionNull, nil)
+	detector.On("NeedAction", decimal.NewFromInt(99)).Return(entity.ActionSell, nil)
+
+	anomalyDetector := anomalymock.NewAnomalyDetector(t)
+	anomalyDetector.On("IsAnomaly", mock.Anything).Return(false, nil)
+
+	exposure := NewExposureTracker(decimal.NewFromInt(1000), decimal.NewFromInt(100))
+	ts, err := NewTradeService(zap.NewNop(), pair, decimal.NewFromInt(5), pricer, detector, trader, anomalyDetector, exposure, WalConfig{})
+	require.NoError(t, err)
+	defer ts.Close()
+	stopper := &recordingStopper{}
+	ts.SetTrailingStop(stopper, decimal.NewFromInt(2))
+
+	event, err := ts.Trade()
+	require.NoError(t, err)
+	require.Equal(t, entity.ActionBuy, event.Action)
+
+	// the stop fired between cycles, position is closed instead of a DCA buy
+	stopper.filled, stopper.fillPrice = event.Amount, decimal.RequireFromString("98.5")
+	trader.base = decimal.Zero
+	event, err = ts.Trade()
+	require.NoError(t, err)
+	require.Equal(t, entity.ActionSell, event.Action)
+	require.Equal(t, entity.TradeReasonTrailingStop, event.Reason)
+	require.Equal(t, "98.5", event.Price.String())
+	require.Equal(t, 1, trader.buys)
+	require.True(t, ts.Position().IsZero())
+	require.True(t, exposure.Deployed().IsZero(), "exposure of sold position must be released")
+
+	// there is nothing to sell anymore
+	event, err = ts.Trade()
+	require.NoError(t, err)
+	require.Nil(t, event)
+	require.Equal(t, 0, trader.sells)
+
+	meta, err := ts.wal.GetLastBuyMeta()
+	require.NoError(t, err)
+	require.True(t, meta.position.IsZero())
+	require.Equal(t, "98.5", meta.price.String())
+}
+
+func TestTradeTrailingStopStaleFill(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	pair := entity.Pair{From: "BTC", To: "USD"}
+	pricer := &dcaPricer{prices: []int64{100, 100}}
+	trader := &baseTrader{quoteTrader: quoteTrader{pricer: pricer, balance: decimal.NewFromInt(1000)}, base: decimal.NewFromInt(1)}
+
+	detector := detectormock.NewDetector(t)
+	detector.On("NeedAction", mock.Anything).Return(entity.ActionBuy, nil)
+
+	anomalyDetector := anomalymock.NewAnomalyDetector(t)
+	anomalyDetector.On("IsAnomaly", mock.Anything).Return(false, nil)
+
+	ts, err := NewTradeService(zap.NewNop(), pair, decimal.NewFromInt(5), pricer, detector, trader, anomalyDetector, nil, WalConfig{})
+	require.NoError(t, err)
+	defer ts.Close()
+	// stop of the previous series reports its fill, but the position is still held
+	stopper := &recordingStopper{filled: decimal.NewFromInt(1), fillPrice: decimal.NewFromInt(90)}
+	ts.SetTrailingStop(stopper, decimal.NewFromInt(2))
+
+	_, err = ts.Trade()
+	require.NoError(t, err)
+	event, err := ts.Trade()
+	require.NoError(t, err)
+	require.Nil(t, event)
+	require.Equal(t, "1", ts.Position().String())
+}
+
+func TestReconcilePosition(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	pair := entity.Pair{From: "BTC", To: "USD"}
+	newService := func() *TradeService {
+		ts, err := NewTradeService(zap.NewNop(), pair, decimal.NewFromInt(10), nil, nil, nil, nil, nil, WalConfig{})
+		require.NoError(t, err)
+		return ts
+	}
+
+	// bot crashed after the second fill, only the first one is saved
+	ts := newService()
+	require.NoError(t, ts.wal.Write("lastbuy", decimal.NewFromInt(100)))
+	require.NoError(t, ts.wal.Write("position", decimal.NewFromInt(2)))
+	require.NoError(t, ts.Close())
+
+	ts = newService()
+	assert.Equal(t, "2", ts.bought.String(), "position must be restored from WAL")
+
+	require.NoError(t, ts.ReconcilePosition(decimal.RequireFromString("2.01"), decimal.NewFromInt(90), true))
+	assert.Equal(t, "2", ts.bought.String(), "insignificant mismatch must be ignored")
+
+	require.NoError(t, ts.ReconcilePosition(decimal.NewFromInt(4), decimal.NewFromInt(90), false))
+	assert.Equal(t, "2", ts.bought.String(), "mismatch must be only logged without adopt")
+
+	require.NoError(t, ts.ReconcilePosition(decimal.NewFromInt(4), decimal.NewFromInt(90), true))
+	assert.Equal(t, "4", ts.bought.String())
+	assert.Equal(t, "100", ts.lastBuyPrice.String(), "known entry price must be kept")
+	require.NoError(t, ts.Close())
+
+	ts = newService()
+	defer ts.Close()
+	assert.Equal(t, "4", ts.bought.String(), "adopted position must be persisted")
+}
+
+func TestInstancesKeepIndependentState(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	pair := entity.Pair{From: "BTC", To: "USD"}
+	newService := func(instanceID string) *TradeService {
+		ts, err := NewTradeService(zap.NewNop(), pair, decimal.NewFromInt(10), nil, nil, nil, nil, nil,
+			WalConfig{Dir: WalDir(pair, instanceID)})
+		require.NoError(t, err)
+		ts.SetInstanceID(instanceID)
+		return ts
+	}
+
+	fast, slow := newService("fast"), newService("slow")
+	require.NoError(t, fast.wal.Write("position", decimal.NewFromInt(2)))
+	require.NoError(t, slow.wal.Write("position", decimal.NewFromInt(5)))
+	assert.Equal(t, "BTC_USD-fast", fast.id)
+	assert.Equal(t, "BTC_USD-slow", slow.id)
+	require.NoError(t, fast.Close())
+	require.NoError(t, slow.Close())
+
+	fast, slow = newService("fast"), newService("slow")
+	defer fast.Close()
+	defer slow.Close()
+	assert.Equal(t, "2", fast.bought.String())
+	assert.Equal(t, "5", slow.bought.String())
+}
+
+// lockedTrader has part of quote balance locked in open orders.
+type lockedTrader struct {
+	quoteTrader
+	locked decimal.Decimal
+}
+
+func (t *lockedTrader) GetBalanceDetailed(_ string) (entity.Balance, error) {
+	return entity.Balance{Free: t.balance, Locked: t.locked}, nil
+}
+
+func TestCheckBalanceUsesFreeBalance(t *testing.T) {
+	ts := &TradeService{
+		pair:   entity.Pair{From: "BTC", To: "USD"},
+		trader: &lockedTrader{quoteTrader: quoteTrader{balance: decimal.NewFromInt(50)}, locked: decimal.NewFromInt(500)},
+	}
+
+	// total balance covers the buy, but locked funds can't be spent
+	err := ts.checkBalance(decimal.NewFromInt(100))
+	assert.ErrorIs(t, err, ErrInsufficientBalance)
+	assert.Contains(t, err.Error(), "available 50 (reserve 0, locked 500), shortfall 50")
+	assert.NoError(t, ts.checkBalance(decimal.NewFromInt(40)))
+
+	// traders without detailed balance are adapted
+	ts.trader = &quoteTrader{balance: decimal.NewFromInt(50)}
+	balance, err := balanceDetailed(ts.trader, "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, "50", balance.Free.String())
+	assert.True(t, balance.Locked.IsZero())
+}
+
+// slowTrader moves the clock on balance check, like a slow exchange round trip.
+type slowTrader struct {
+	quoteTrader
+	clock *time.Time
+	delay time.Duration
+}
+
+func (t *slowTrader) GetBalance(currency string) (decimal.Decimal, error) {
+	*t.clock = t.clock.Add(t.delay)
+	return t.quoteTrader.GetBalance(currency)
+}
+
+func TestTradeRefreshesStalePrice(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	pair := entity.Pair{From: "BTC", To: "USD"}
+	// every trade fetches a price for decision and a fresh one before sending the order
+	pricer := &dcaPricer{prices: []int64{100, 100, 95, 101, 90, 89}}
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	trader := &slowTrader{quoteTrader: quoteTrader{pricer: pricer, balance: decimal.NewFromInt(1000)}, clock: &clock, delay: 10 * time.Second}
+
+	detector := detectormock.NewDetector(t)
+	detector.On("NeedAction", decimal.NewFromInt(100)).Return(entity.ActionBuy, nil)
+	detector.On("NeedAction", mock.Anything).Return(entity.ActionNull, nil)
+
+	anomalyDetector := anomalymock.NewAnomalyDetector(t)
+	anomalyDetector.On("IsAnomaly", mock.Anything).Return(false, nil)
+
+	exposure := NewExposureTracker(decimal.NewFromInt(1000), decimal.NewFromInt(100))
+	ts, err := NewTradeService(zap.NewNop(), pair, decimal.NewFromInt(5), pricer, detector, trader, anomalyDetector, exposure, WalConfig{})
+	require.NoError(t, err)
+	defer ts.Close()
+	ts.now = func() time.Time { return clock }
+	ts.SetMaxPriceAge(5 * time.Second)
+
+	event, err := ts.Trade()
+	require.NoError(t, err)
+	require.Equal(t, entity.ActionBuy, event.Action)
+	require.Equal(t, 2, pricer.n, "stale price must be fetched again")
+
+	// price bounced above the first buy while balance was checked, DCA buy is cancelled before sending
+	event, err = ts.Trade()
+	require.NoError(t, err)
+	require.Nil(t, event)
+	require.Equal(t, 1, trader.buys)
+	require.Equal(t, "1", ts.tradePart.String())
+	require.Equal(t, "100", exposure.Deployed().String(), "exposure of cancelled buy must be released")
+
+	event, err = ts.Trade()
+	require.NoError(t, err)
+	require.Equal(t, entity.TradeReasonDCA, event.Reason)
+	require.Equal(t, "89", event.Price.String(), "buy must be recorded at the fresh price")
+	require.Equal(t, 2, trader.buys)
+}
+
+// bookQuoter returns preset best prices.
+type bookQuoter struct {
+	bid, ask decimal.Decimal
+}
+
+func (q *bookQuoter) BestPrices(_ entity.Pair) (decimal.Decimal, decimal.Decimal, error) {
+	return q.bid, q.ask, nil
+}
+
+func TestTradeAbortsOnSlippage(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	pair := entity.Pair{From: "BTC", To: "USD"}
+	pricer := &dcaPricer{prices: []int64{100, 100, 110, 110}}
+	trader := &quoteTrader{pricer: pricer, balance: decimal.NewFromInt(1000)}
+
+	detector := detectormock.NewDetector(t)
+	detector.On("NeedAction", decimal.NewFromInt(100)).Return(entity.ActionBuy, nil)
+	detector.On("NeedAction", decimal.NewFromInt(110)).Return(entity.ActionSell, nil)
+
+	anomalyDetector := anomalymock.NewAnomalyDetector(t)
+	anomalyDetector.On("IsAnomaly", mock.Anything).Return(false, nil)
+
+	ts, err := NewTradeService(zap.NewNop(), pair, decimal.NewFromInt(5), pricer, detector, trader, anomalyDetector, nil, WalConfig{})
+	require.NoError(t, err)
+	defer ts.Close()
+	quoter := &bookQuoter{bid: decimal.NewFromInt(99), ask: decimal.NewFromInt(101)}
+	ts.SetMaxSlippage(quoter, decimal.RequireFromString("0.5"))
+
+	// ask is 1% above the price
+	event, err := ts.Trade()
+	require.NoError(t, err)
+	require.Nil(t, event)
+	require.Equal(t, 0, trader.buys, "buy with excessive slippage must not be sent")
+
+	quoter.ask = decimal.RequireFromString("100.2")
+	event, err = ts.Trade()
+	require.NoError(t, err)
+	require.Equal(t, entity.ActionBuy, event.Action)
+	require.Equal(t, 1, trader.buys)
+
+	// bid is 0.9% below the price
+	quoter.bid = decimal.NewFromInt(109)
+	event, err = ts.Trade()
+	require.NoError(t, err)
+	require.Nil(t, event)
+	require.Equal(t, 0, trader.sells, "sell with excessive slippage must not be sent")
+
+	quoter.bid = decimal.RequireFromString("109.8")
+	event, err = ts.Trade()
+	require.NoError(t, err)
+	require.Equal(t, entity.ActionSell, event.Action)
+	require.Equal(t, 1, trader.sells)
+}
+
+func TestTradeSpans(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer provider.Shutdown(context.Background())
+	otel.SetTracerProvider(provider)
+
+	pair := entity.Pair{From: "BTC", To: "USD"}
+	pricer := &dcaPricer{prices: []int64{100, 101}}
+	trader := &quoteTrader{pricer: pricer, balance: decimal.NewFromInt(1000)}
+
+	detector := detectormock.NewDetector(t)
+	detector.On("NeedAction", decimal.NewFromInt(100)).Return(entity.ActionBuy, nil)
+	detector.On("NeedAction", mock.Anything).Return(entity.ActionNull, nil)
+
+	anomalyDetector := anomalymock.NewAnomalyDetector(t)
+	anomalyDetector.On("IsAnomaly", mock.Anything).Return(false, nil)
+
+	ts, err := NewTradeService(zap.NewNop(), pair, decimal.NewFromInt(5), pricer, detector, trader, anomalyDetector, nil, WalConfig{})
+	require.NoError(t, err)
+	defer ts.Close()
+
+	for range pricer.prices {
+		_, err = ts.Trade()
+		require.NoError(t, err)
+	}
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 3)
+
+	// order span ends first and is a child of the cycle which placed it
+	order, buyCycle, idleCycle := spans[0], spans[1], spans[2]
+	require.Equal(t, "place_order", order.Name)
+	require.Equal(t, buyCycle.SpanContext.SpanID(), order.Parent.SpanID())
+	require.Contains(t, order.Attributes, attribute.String("pair", "BTC_USD"))
+	require.Contains(t, order.Attributes, attribute.String("action", entity.ActionBuy.String()))
+
+	require.Equal(t, "trade_cycle", buyCycle.Name)
+	require.Contains(t, buyCycle.Attributes, attribute.String("pair", "BTC_USD"))
+	require.Contains(t, buyCycle.Attributes, attribute.String("action", entity.ActionBuy.String()))
+
+	require.Equal(t, "trade_cycle", idleCycle.Name)
+	require.NotContains(t, idleCycle.Attributes, attribute.String("action", entity.ActionBuy.String()))
+	require.NotEqual(t, buyCycle.SpanContext.TraceID(), idleCycle.SpanContext.TraceID())
+}
@@ -0,0 +1,296 @@
+package turnover
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"github.com/vadiminshakov/marti/entity"
+	"github.com/vadiminshakov/marti/services"
+	"go.uber.org/zap"
+)
+
+// sealName authenticates encrypted turnover, so it can't be swapped with other encrypted state.
+const sealName = "turnover"
+
+// window is the longest period turnover is limited over, older trades are forgotten.
+const window = 24 * time.Hour
+
+// AllBots resumes turnover of all bots, including the global one.
+const AllBots = "all"
+
+// Limits are max notional in quote currency traded within an hour and within a day, zero means no limit.
+type Limits struct {
+	Hourly decimal.Decimal
+	Daily  decimal.Decimal
+}
+
+type trade struct {
+	Bot      string          `json:"bot"`
+	Time     time.Time       `json:"time"`
+	Notional decimal.Decimal `json:"notional"`
+}
+
+// state is the persisted part of tracker.
+type state struct {
+	Trades []trade `json:"trades"`
+	// Resumed holds time of manual resume by bot, AllBots resumes all of them
+	Resumed map[string]time.Time `json:"resumed,omitempty"`
+}
+
+// Tracker tracks notional traded by all bots within the last day to stop runaway buy and sell loops.
+// Trades are persisted, so restarts don't reset the limits. Once a limit is reached the bot is tripped,
+// and its buys are blocked until the window rolls or turnover is resumed manually.
+type Tracker struct {
+	mu      sync.Mutex
+	path    string
+	key     []byte
+	global  Limits
+	trades  []trade
+	resumed map[string]time.Time
+	tripped map[string]bool
+	now     func() time.Time
+}
+
+// NewTracker creates tracker persisting trades to path, global limits apply to trades of all bots.
+// Trades are encrypted with the data encryption key like WAL, key may be empty if encryption is disabled.
+func NewTracker(path string, global Limits, key []byte) (*Tracker, error) {
+	t := &Tracker{path: path, key: key, global: global, resumed: make(map[string]time.Time), tripped: make(map[string]bool),
+		now: time.Now}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read turnover")
+	}
+
+	if data, err = services.OpenData(key, sealName, data); err != nil {
+		return nil, errors.Wrapf(err, "failed to decrypt turnover from %s", path)
+	}
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode turnover from %s", path)
+	}
+	t.trades = st.Trades
+	for bot, at := range st.Resumed {
+		t.resumed[bot] = at
+	}
+
+	return t, nil
+}
+
+// Allow returns services.ErrBuyBlocked if trading notional more by the bot would exceed its limits or the global ones.
+func (t *Tracker) Allow(bot string, limits Limits, notional decimal.Decimal) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	delete(t.tripped, bot)
+	for _, l := range []struct {
+		name   string
+		bot    string
+		period time.Duration
+		limit  decimal.Decimal
+	}{
+		{"hourly", bot, time.Hour, limits.Hourly},
+		{"daily", bot, window, limits.Daily},
+		{"global hourly", "", time.Hour, t.global.Hourly},
+		{"global daily", "", window, t.global.Daily},
+	} {
+		if !l.limit.IsPositive() {
+			continue
+		}
+		traded := t.traded(l.bot, now.Add(-l.period))
+		if traded.Add(notional).GreaterThan(l.limit) {
+			t.tripped[bot] = true
+			return errors.Wrapf(services.ErrBuyBlocked, "%s turnover limit %s is reached, traded %s",
+				l.name, l.limit.String(), traded.String())
+		}
+	}
+
+	return nil
+}
+
+// Tripped returns true if the last buy of the bot was blocked by a turnover limit.
+func (t *Tracker) Tripped(bot string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.tripped[bot]
+}
+
+// Resume makes turnover traded by the bot before now not count towards its limits, so its buys are not blocked
+// anymore. AllBots resumes every bot and the global limits.
+func (t *Tracker) Resume(bot string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.resumed[bot] = t.now()
+	if bot == AllBots {
+		clear(t.tripped)
+	} else {
+		delete(t.tripped, bot)
+	}
+
+	return t.save()
+}
+
+// traded returns notional traded since the time by the bot, or by all bots if bot is empty.
+// Trades before manual resume are not counted.
+func (t *Tracker) traded(bot string, since time.Time) decimal.Decimal {
+	if at := t.resumed[AllBots]; at.After(since) {
+		since = at
+	}
+	if at, ok := t.resumed[bot]; ok && bot != "" && at.After(since) {
+		since = at
+	}
+
+	sum := decimal.Zero
+	for _, tr := range t.trades {
+		if tr.Time.After(since) && (bot == "" || tr.Bot == bot) {
+			sum = sum.Add(tr.Notional)
+		}
+	}
+
+	return sum
+}
+
+// Record saves notional traded by the bot.
+func (t *Tracker) Record(bot string, notional decimal.Decimal) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	trades := t.trades[:0]
+	for _, tr := range t.trades {
+		if tr.Time.After(now.Add(-window)) {
+			trades = append(trades, tr)
+		}
+	}
+	t.trades = append(trades, trade{Bot: bot, Time: now, Notional: notional})
+
+	return t.save()
+}
+
+func (t *Tracker) save() error {
+	data, err := json.Marshal(state{Trades: t.trades, Resumed: t.resumed})
+	if err != nil {
+		return errors.Wrap(err, "failed to encode turnover")
+	}
+	if data, err = services.SealData(t.key, sealName, data); err != nil {
+		return errors.Wrap(err, "failed to encrypt turnover")
+	}
+	if err := os.MkdirAll(filepath.Dir(t.path), 0o755); err != nil {
+		return errors.Wrap(err, "failed to create turnover dir")
+	}
+
+	// file is replaced at once, so a crash never leaves it half written
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return errors.Wrap(err, "failed to write turnover")
+	}
+
+	return errors.Wrap(os.Rename(tmp, t.path), "failed to write turnover")
+}
+
+// Trader limits turnover of the wrapped trader. Buys beyond the limits are blocked, sells are never blocked,
+// so positions can still be closed.
+type Trader struct {
+	services.Trader
+	l       *zap.Logger
+	pricer  services.Pricer
+	pair    entity.Pair
+	bot     string
+	limits  Limits
+	tracker *Tracker
+	// lastPrice values sells when the pricer fails
+	lastPrice decimal.Decimal
+}
+
+// NewTrader wraps trader of the bot, pricer values traded amounts in quote currency.
+func NewTrader(l *zap.Logger, trader services.Trader, pricer services.Pricer, pair entity.Pair, bot string, limits Limits,
+	tracker *Tracker) *Trader {
+	return &Trader{Trader: trader, l: l, pricer: pricer, pair: pair, bot: bot, limits: limits, tracker: tracker}
+}
+
+// Buy buys amount if it doesn't exceed turnover limits, services.ErrBuyBlocked is returned otherwise.
+func (t *Trader) Buy(amount decimal.Decimal) error {
+	notional, err := t.notional(amount)
+	if err != nil {
+		return err
+	}
+	tripped := t.tracker.Tripped(t.bot)
+	if err := t.tracker.Allow(t.bot, t.limits, notional); err != nil {
+		if !tripped {
+			t.l.Error("turnover limit is tripped, buys are blocked until the window rolls or turnover is resumed",
+				zap.String("bot", t.bot), zap.Error(err))
+		}
+		return errors.Wrapf(err, "buy of %s %s", amount.String(), t.pair.From)
+	}
+	if err := t.Trader.Buy(amount); err != nil {
+		return err
+	}
+	t.record(notional)
+
+	return nil
+}
+
+// Sell sells amount, the sold notional counts towards turnover. The sell is never blocked, it is valued
+// after it is sent, at the last known price if the pricer fails.
+func (t *Trader) Sell(amount decimal.Decimal) error {
+	if err := t.Trader.Sell(amount); err != nil {
+		return err
+	}
+
+	notional, err := t.notional(amount)
+	if err != nil {
+		if !t.lastPrice.IsPositive() {
+			t.l.Error("sell is not counted towards turnover", zap.String("bot", t.bot), zap.Error(err))
+			return nil
+		}
+		t.l.Warn("sell is valued at the last known price", zap.String("bot", t.bot),
+			zap.String("price", t.lastPrice.String()), zap.Error(err))
+		notional = amount.Mul(t.lastPrice)
+	}
+	t.record(notional)
+
+	return nil
+}
+
+// record records executed trade, failure to persist it must not fail the trade.
+func (t *Trader) record(notional decimal.Decimal) {
+	if err := t.tracker.Record(t.bot, notional); err != nil {
+		t.l.Error("failed to persist turnover", zap.String("bot", t.bot), zap.Error(err))
+	}
+}
+
+// GetBalanceDetailed returns detailed balance if the wrapped trader reports it,
+// otherwise the whole balance is considered free.
+func (t *Trader) GetBalanceDetailed(currency string) (entity.Balance, error) {
+	if b, ok := t.Trader.(services.DetailedBalancer); ok {
+		return b.GetBalanceDetailed(currency)
+	}
+
+	free, err := t.Trader.GetBalance(currency)
+	if err != nil {
+		return entity.Balance{}, err
+	}
+
+	return entity.Balance{Free: free}, nil
+}
+
+func (t *Trader) notional(amount decimal.Decimal) (decimal.Decimal, error) {
+	price, err := t.pricer.GetPrice(t.pair)
+	if err != nil {
+		return decimal.Zero, errors.Wrapf(err, "failed to value turnover of %s", t.pair.String())
+	}
+	t.lastPrice = price
+
+	return amount.Mul(price), nil
+}
@@ -0,0 +1,196 @@
+package turnover
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/vadiminshakov/marti/entity"
+	"github.com/vadiminshakov/marti/services"
+	tradermock "github.com/vadiminshakov/marti/services/trader/mock"
+	"go.uber.org/zap"
+)
+
+type fixedPricer struct {
+	price decimal.Decimal
+}
+
+func (p fixedPricer) GetPrice(_ entity.Pair) (decimal.Decimal, error) {
+	return p.price, nil
+}
+
+// failingPricer fails once price is stale.
+type failingPricer struct {
+	price decimal.Decimal
+	stale bool
+}
+
+func (p *failingPricer) GetPrice(_ entity.Pair) (decimal.Decimal, error) {
+	if p.stale {
+		return decimal.Zero, errors.New("price is stale")
+	}
+	return p.price, nil
+}
+
+func TestTraderLimitsTurnover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "turnover.json")
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	newTracker := func() *Tracker {
+		tracker, err := NewTracker(path, Limits{}, nil)
+		require.NoError(t, err)
+		tracker.now = func() time.Time { return now }
+		return tracker
+	}
+
+	inner := tradermock.NewTrader(t)
+	inner.On("Buy", mock.Anything).Return(nil)
+	inner.On("Sell", mock.Anything).Return(nil)
+
+	pair := entity.Pair{From: "BTC", To: "USDT"}
+	limits := Limits{Hourly: decimal.NewFromInt(1000), Daily: decimal.NewFromInt(5000)}
+	trader := NewTrader(zap.NewNop(), inner, fixedPricer{decimal.NewFromInt(100)}, pair, "BTC_USDT", limits, newTracker())
+
+	// rapid cycles of 400 notional cross the hourly limit on the third trade
+	require.NoError(t, trader.Buy(decimal.NewFromInt(4)))
+	require.NoError(t, trader.Sell(decimal.NewFromInt(4)))
+	err := trader.Buy(decimal.NewFromInt(4))
+	require.ErrorIs(t, err, services.ErrBuyBlocked)
+	require.ErrorContains(t, err, "hourly turnover limit 1000 is reached, traded 800")
+	inner.AssertNumberOfCalls(t, "Buy", 1)
+
+	// position can still be closed
+	require.NoError(t, trader.Sell(decimal.NewFromInt(4)))
+	inner.AssertNumberOfCalls(t, "Sell", 2)
+
+	// restart doesn't reset the window
+	trader = NewTrader(zap.NewNop(), inner, fixedPricer{decimal.NewFromInt(100)}, pair, "BTC_USDT", limits, newTracker())
+	require.ErrorIs(t, trader.Buy(decimal.NewFromInt(1)), services.ErrBuyBlocked)
+
+	// trading is restored once the hour rolls
+	now = now.Add(time.Hour)
+	require.NoError(t, trader.Buy(decimal.NewFromInt(4)))
+	inner.AssertNumberOfCalls(t, "Buy", 2)
+}
+
+func TestTrackerGlobalLimits(t *testing.T) {
+	tracker, err := NewTracker(filepath.Join(t.TempDir(), "turnover.json"), Limits{Daily: decimal.NewFromInt(1000)},
+		nil)
+	require.NoError(t, err)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+
+	require.NoError(t, tracker.Allow("BTC_USDT", Limits{}, decimal.NewFromInt(600)))
+	require.NoError(t, tracker.Record("BTC_USDT", decimal.NewFromInt(600)))
+	require.NoError(t, tracker.Allow("ETH_USDT", Limits{}, decimal.NewFromInt(400)))
+	require.NoError(t, tracker.Record("ETH_USDT", decimal.NewFromInt(400)))
+
+	err = tracker.Allow("ETH_USDT", Limits{}, decimal.NewFromInt(1))
+	require.ErrorIs(t, err, services.ErrBuyBlocked)
+	require.ErrorContains(t, err, "global daily")
+
+	// trades older than a day are forgotten
+	now = now.Add(window + time.Second)
+	require.NoError(t, tracker.Allow("ETH_USDT", Limits{}, decimal.NewFromInt(1000)))
+	require.NoError(t, tracker.Record("ETH_USDT", decimal.NewFromInt(1)))
+	require.Len(t, tracker.trades, 1)
+}
+
+func TestTrackerResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "turnover.json")
+	tracker, err := NewTracker(path, Limits{Daily: decimal.NewFromInt(1500)}, nil)
+	require.NoError(t, err)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+
+	limits := Limits{Hourly: decimal.NewFromInt(1000)}
+	require.NoError(t, tracker.Record("BTC_USDT", decimal.NewFromInt(1000)))
+	require.NoError(t, tracker.Record("ETH_USDT", decimal.NewFromInt(500)))
+	require.ErrorIs(t, tracker.Allow("BTC_USDT", limits, decimal.NewFromInt(1)), services.ErrBuyBlocked)
+	require.True(t, tracker.Tripped("BTC_USDT"))
+	require.False(t, tracker.Tripped("ETH_USDT"))
+
+	// resume of the bot resets its own limits, but not the global ones
+	now = now.Add(time.Minute)
+	require.NoError(t, tracker.Resume("BTC_USDT"))
+	require.False(t, tracker.Tripped("BTC_USDT"))
+	err = tracker.Allow("BTC_USDT", limits, decimal.NewFromInt(1))
+	require.ErrorIs(t, err, services.ErrBuyBlocked)
+	require.ErrorContains(t, err, "global daily")
+
+	// resume survives restart
+	now = now.Add(time.Minute)
+	require.NoError(t, tracker.Resume(AllBots))
+	tracker, err = NewTracker(path, Limits{Daily: decimal.NewFromInt(1500)}, nil)
+	require.NoError(t, err)
+	tracker.now = func() time.Time { return now }
+	require.NoError(t, tracker.Allow("BTC_USDT", limits, decimal.NewFromInt(1000)))
+	require.Len(t, tracker.trades, 2, "resume must not forget trades")
+}
+
+func TestTrackerEncryptsTurnover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "turnover.json")
+	key := make([]byte, 32)
+	tracker, err := NewTracker(path, Limits{}, key)
+	require.NoError(t, err)
+	require.NoError(t, tracker.Record("BTC_USDT", decimal.NewFromInt(100)))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "BTC_USDT")
+
+	tracker, err = NewTracker(path, Limits{}, key)
+	require.NoError(t, err)
+	require.Len(t, tracker.trades, 1)
+	require.Equal(t, "100", tracker.trades[0].Notional.String())
+
+	_, err = NewTracker(path, Limits{}, nil)
+	require.ErrorIs(t, err, services.ErrNoEncryptionKey)
+}
+
+func TestTrackerReadsPlaintextTurnoverWithKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "turnover.json")
+	tracker, err := NewTracker(path, Limits{}, nil)
+	require.NoError(t, err)
+	require.NoError(t, tracker.Record("BTC_USDT", decimal.NewFromInt(100)))
+
+	// turnover written before encryption was enabled is encrypted on the next write
+	key := make([]byte, 32)
+	tracker, err = NewTracker(path, Limits{}, key)
+	require.NoError(t, err)
+	require.Len(t, tracker.trades, 1)
+	require.NoError(t, tracker.Record("BTC_USDT", decimal.NewFromInt(100)))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "BTC_USDT")
+}
+
+func TestTraderSellWithFailingPricer(t *testing.T) {
+	tracker, err := NewTracker(filepath.Join(t.TempDir(), "turnover.json"), Limits{}, nil)
+	require.NoError(t, err)
+
+	inner := tradermock.NewTrader(t)
+	inner.On("Buy", mock.Anything).Return(nil)
+	inner.On("Sell", mock.Anything).Return(nil)
+
+	pricer := &failingPricer{price: decimal.NewFromInt(100)}
+	trader := NewTrader(zap.NewNop(), inner, pricer, entity.Pair{From: "BTC", To: "USDT"}, "BTC_USDT", Limits{}, tracker)
+	require.NoError(t, trader.Buy(decimal.NewFromInt(2)))
+
+	// sells are never blocked, they are valued at the last known price
+	pricer.stale = true
+	require.NoError(t, trader.Sell(decimal.NewFromInt(2)))
+	inner.AssertNumberOfCalls(t, "Sell", 1)
+	require.Len(t, tracker.trades, 2)
+	require.Equal(t, "200", tracker.trades[1].Notional.String())
+
+	// without any known price the sell is still sent
+	trader = NewTrader(zap.NewNop(), inner, pricer, entity.Pair{From: "BTC", To: "USDT"}, "BTC_USDT", Limits{}, tracker)
+	require.NoError(t, trader.Sell(decimal.NewFromInt(1)))
+	inner.AssertNumberOfCalls(t, "Sell", 2)
+}
@@ -1,78 +1,322 @@
 package services
 
 import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/pkg/errors"
 	"github.com/shopspring/decimal"
 	"github.com/vadiminshakov/gowal"
+	"github.com/vadiminshakov/marti/entity"
+)
+
+const (
+	// maxWriteAttempts limits the number of indexes probed when the next WAL index is already taken.
+	maxWriteAttempts = 10
+	// walSignificantDigits is the precision of decimals persisted in WAL, enough to be lossless for money math.
+	walSignificantDigits = 18
+
+	walDir        = "waldata"
+	walSegmentPfx = "seg_"
+	walLockFile   = "LOCK"
 )
 
-var ErrNoData = errors.New("no data in WAL")
+var (
+	ErrNoData = errors.New("no data in WAL")
+	// ErrWalLocked is returned if the WAL directory is used by a running bot.
+	ErrWalLocked = errors.New("WAL is used by a running bot")
+)
 
 type BuyMetaData struct {
 	price  decimal.Decimal
 	amount decimal.Decimal
+	// position is the whole amount of asset bought by the bot and not sold yet
+	position decimal.Decimal
+}
+
+// WalConfig holds optional settings of the WAL.
+type WalConfig struct {
+	// Dir is the WAL directory, "waldata" if empty.
+	Dir string
+	// EncryptionKey enables AES-GCM encryption of record values at rest if set, must be 32 bytes.
+	EncryptionKey []byte
+	// SyncMode defines when writes are synced to disk, WalSyncAlways if empty.
+	SyncMode WalSyncMode
+	// SyncEvery and SyncInterval bound unsynced writes in WalSyncInterval mode, 100 writes and 1s if zero.
+	SyncEvery    int
+	SyncInterval time.Duration
 }
 
 type WrappedWal struct {
-	wal *gowal.Wal
+	mu     sync.Mutex
+	wal    *gowal.Wal
+	cipher *walCipher
+	dir    string
+	unlock func() error
+
+	// interval sync mode state
+	syncEvery   int
+	pending     int
+	syncErr     error
+	stopFlusher chan struct{}
+	flusherDone chan struct{}
 }
 
-func NewWrappedWal() (*WrappedWal, error) {
+func NewWrappedWal(cfg WalConfig) (*WrappedWal, error) {
+	if err := cfg.SyncMode.validate(); err != nil {
+		return nil, err
+	}
+
+	var c *walCipher
+	if len(cfg.EncryptionKey) > 0 {
+		var err error
+		if c, err = newWalCipher(cfg.EncryptionKey); err != nil {
+			return nil, err
+		}
+	}
+
+	dir := cfg.Dir
+	if dir == "" {
+		dir = walDir
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "error create wal dir")
+	}
+	unlock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
 	w, err := gowal.NewWAL(gowal.Config{
-		Dir:              "waldata",
-		Prefix:           "seg_",
+		Dir:              dir,
+		Prefix:           walSegmentPfx,
 		SegmentThreshold: 1000,
 		MaxSegments:      10,
-		IsInSyncDiskMode: true,
+		IsInSyncDiskMode: cfg.SyncMode == "" || cfg.SyncMode == WalSyncAlways,
 	})
 
 	if err != nil {
+		unlock()
 		return nil, errors.Wrap(err, "error init wal")
 	}
 
-	return &WrappedWal{w}, nil
+	wrapped := &WrappedWal{wal: w, cipher: c, dir: dir, unlock: unlock}
+	if cfg.SyncMode == WalSyncInterval {
+		wrapped.syncEvery = cfg.SyncEvery
+		if wrapped.syncEvery <= 0 {
+			wrapped.syncEvery = defaultWalSyncEvery
+		}
+		interval := cfg.SyncInterval
+		if interval <= 0 {
+			interval = defaultWalSyncInterval
+		}
+		wrapped.stopFlusher = make(chan struct{})
+		wrapped.flusherDone = make(chan struct{})
+		go wrapped.runFlusher(interval)
+	}
+
+	return wrapped, nil
 }
 
+// Write appends value to the log under the next free index.
+// Value is stored in canonical form (see canonicalDecimal), in-memory value is left untouched,
+// and is encrypted if encryption key is set.
+// Writes are serialized, and if the next index is already taken (index collision)
+// the following indexes are probed instead of overwriting or corrupting the log.
 func (w *WrappedWal) Write(key string, data decimal.Decimal) error {
-	b, _ := data.MarshalBinary()
+	b, err := canonicalDecimal(data, walSignificantDigits).MarshalBinary()
+	if err != nil {
+		return errors.Wrapf(err, "error marshal value for key %s", key)
+	}
+	if w.cipher != nil {
+		if b, err = w.cipher.seal(key, b); err != nil {
+			return errors.Wrapf(err, "error encrypt value for key %s", key)
+		}
+	}
 
-	err := w.wal.Write(w.wal.CurrentIndex()+1, key, b)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	index := w.wal.CurrentIndex() + 1
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		err = w.wal.Write(index, key, b)
+		if !errors.Is(err, gowal.ErrExists) {
+			break
+		}
+		index++
+	}
 	if err != nil {
-		panic(err)
+		return errors.Wrapf(err, "error write key %s to wal at index %d", key, index)
+	}
+
+	if w.syncEvery > 0 {
+		w.pending++
+		if w.pending >= w.syncEvery || w.syncErr != nil {
+			return w.syncPending()
+		}
 	}
 
 	return nil
 }
 
+// canonicalDecimal rounds d to sigDigits significant digits and strips trailing zeros,
+// so the same value is always persisted with the same (and shortest) encoding.
+// Records written before canonical encoding are still read as is.
+func canonicalDecimal(d decimal.Decimal, sigDigits int32) decimal.Decimal {
+	if d.IsZero() {
+		return decimal.Zero
+	}
+
+	digits := int32(len(new(big.Int).Abs(d.Coefficient()).String()))
+	if extra := digits - sigDigits; extra > 0 {
+		d = d.Round(-(d.Exponent() + extra))
+	}
+
+	coef, exp := d.Coefficient(), d.Exponent()
+	ten, rem := big.NewInt(10), new(big.Int)
+	for {
+		q, r := new(big.Int).QuoRem(coef, ten, rem)
+		if r.Sign() != 0 {
+			break
+		}
+		coef = q
+		exp++
+	}
+
+	return decimal.NewFromBigInt(coef, exp)
+}
+
 func (w *WrappedWal) GetLastBuyMeta() (BuyMetaData, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
 	if w.wal.CurrentIndex() == 0 {
 		return BuyMetaData{}, ErrNoData
 	}
 
-	lastBuyPrice, lastAmount := decimal.Zero, decimal.Zero
+	lastBuyPrice, lastAmount, position := decimal.Zero, decimal.Zero, decimal.Zero
 	noData := true
 	for m := range w.wal.Iterator() {
 		noData = false
 
+		if m.Key != "lastbuy" && m.Key != "lastamount" && m.Key != "position" {
+			continue
+		}
+		value, err := openRecord(w.cipher, m.Key, m.Value)
+		if err != nil {
+			return BuyMetaData{}, err
+		}
+
 		if m.Key == "lastbuy" {
-			if err := lastBuyPrice.UnmarshalBinary(m.Value); err != nil {
+			if err := lastBuyPrice.UnmarshalBinary(value); err != nil {
 				return BuyMetaData{}, errors.Wrap(err, "error unmarshal last buy price")
 			}
 		}
 		if m.Key == "lastamount" {
-			if err := lastAmount.UnmarshalBinary(m.Value); err != nil {
+			if err := lastAmount.UnmarshalBinary(value); err != nil {
 				return BuyMetaData{}, errors.Wrap(err, "error unmarshal last amount")
 			}
 		}
+		if m.Key == "position" {
+			if err := position.UnmarshalBinary(value); err != nil {
+				return BuyMetaData{}, errors.Wrap(err, "error unmarshal position")
+			}
+		}
 	}
 
 	if noData {
 		return BuyMetaData{}, ErrNoData
 	}
 
-	return BuyMetaData{lastBuyPrice, lastAmount}, nil
+	return BuyMetaData{lastBuyPrice, lastAmount, position}, nil
 }
 
+// Close syncs pending writes in interval sync mode, closes the log and releases the WAL directory.
 func (w *WrappedWal) Close() error {
+	if w.stopFlusher != nil {
+		close(w.stopFlusher)
+		<-w.flusherDone
+		w.stopFlusher = nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	defer w.unlock()
+
+	if err := w.syncPending(); err != nil {
+		w.wal.Close()
+		return err
+	}
+
 	return w.wal.Close()
 }
+
+// WalDir returns WAL directory of the bot trading pair, instanceID isolates state of bots trading the same pair.
+func WalDir(pair entity.Pair, instanceID string) string {
+	return filepath.Join(walDir, entity.BotID(pair, instanceID))
+}
+
+// ResetWal removes WAL directory with all the state stored in it.
+// It fails with ErrWalLocked if the WAL is used by a running bot.
+func ResetWal(dir string) error {
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	unlock, err := lockDir(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return errors.Wrapf(os.RemoveAll(dir), "error remove wal dir %s", dir)
+}
+
+// MigrateLegacyWal moves segments of the WAL shared by all pairs, which was used before WAL became per pair,
+// to dir. Nothing is moved if dir already has segments.
+func MigrateLegacyWal(dir string) error {
+	if hasSegments(dir) || !hasSegments(walDir) {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, "error create wal dir")
+	}
+
+	entries, err := os.ReadDir(walDir)
+	if err != nil {
+		return errors.Wrap(err, "error read legacy wal dir")
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !strings.HasPrefix(e.Name(), walSegmentPfx) {
+			continue
+		}
+		if err := os.Rename(filepath.Join(walDir, e.Name()), filepath.Join(dir, e.Name())); err != nil {
+			return errors.Wrapf(err, "error move legacy wal file %s", e.Name())
+		}
+	}
+
+	return nil
+}
+
+// HasLegacyWal returns true if the WAL shared by all pairs, which was used before WAL became per pair, has segments.
+func HasLegacyWal() bool {
+	return hasSegments(walDir)
+}
+
+func hasSegments(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.HasPrefix(e.Name(), walSegmentPfx) {
+			return true
+		}
+	}
+
+	return false
+}
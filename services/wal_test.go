@@ -5,13 +5,15 @@ import (
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vadiminshakov/marti/entity"
 	"os"
+	"sync"
 	"testing"
 )
 
 func TestWrappedWal_WriteAndRead(t *testing.T) {
 	// Создаем новый WAL
-	w, err := NewWrappedWal()
+	w, err := NewWrappedWal(WalConfig{})
 	require.NoError(t, err, "Failed to create WrappedWal")
 	defer func() {
 		assert.NoError(t, w.Close(), "Failed to close WAL")
@@ -35,7 +37,7 @@ func TestWrappedWal_WriteAndRead(t *testing.T) {
 }
 
 func TestWrappedWal_EmptyLog(t *testing.T) {
-	w, err := NewWrappedWal()
+	w, err := NewWrappedWal(WalConfig{})
 	require.NoError(t, err, "Failed to create WrappedWal")
 	defer func() {
 		assert.NoError(t, w.Close(), "Failed to close WAL")
@@ -48,7 +50,7 @@ func TestWrappedWal_EmptyLog(t *testing.T) {
 }
 
 func TestWrappedWal_Iterator(t *testing.T) {
-	w, err := NewWrappedWal()
+	w, err := NewWrappedWal(WalConfig{})
 	require.NoError(t, err, "Failed to create WrappedWal")
 	defer func() {
 		assert.NoError(t, w.Close(), "Failed to close WAL")
@@ -78,7 +80,7 @@ func TestWrappedWal_Iterator(t *testing.T) {
 }
 
 func TestWrappedWal_CorruptedData(t *testing.T) {
-	w, err := NewWrappedWal()
+	w, err := NewWrappedWal(WalConfig{})
 	require.NoError(t, err, "Failed to create WrappedWal")
 
 	err = w.Write("lastbuy", decimal.NewFromFloat(100.50))
@@ -94,14 +96,14 @@ func TestWrappedWal_CorruptedData(t *testing.T) {
 
 	fd.Close()
 
-	w, err = NewWrappedWal()
+	w, err = NewWrappedWal(WalConfig{})
 	require.Error(t, err, "Expected an error due to corrupted data")
 
 	os.RemoveAll("waldata")
 }
 
 func TestWalReload(t *testing.T) {
-	w, err := NewWrappedWal()
+	w, err := NewWrappedWal(WalConfig{})
 	require.NoError(t, err, "Не удалось создать WAL")
 
 	price := decimal.NewFromFloat(1234.5678)
@@ -118,7 +120,7 @@ func TestWalReload(t *testing.T) {
 	require.NoError(t, err, "Ошибка закрытия WAL")
 
 	// reload WAL
-	w, err = NewWrappedWal()
+	w, err = NewWrappedWal(WalConfig{})
 	require.NoError(t, err, "Ошибка пересоздания WAL")
 
 	// write data
@@ -134,7 +136,7 @@ func TestWalReload(t *testing.T) {
 	require.NoError(t, err, "Ошибка закрытия WAL")
 
 	// reload WAL
-	w, err = NewWrappedWal()
+	w, err = NewWrappedWal(WalConfig{})
 	require.NoError(t, err, "Ошибка пересоздания WAL")
 
 	err = w.Write("1lastbuy", price)
@@ -145,8 +147,164 @@ func TestWalReload(t *testing.T) {
 	require.NoError(t, err, "Ошибка закрытия WAL")
 
 	// reload WAL
-	w, err = NewWrappedWal()
+	w, err = NewWrappedWal(WalConfig{})
 	require.NoError(t, err, "Ошибка пересоздания WAL")
 
 	os.RemoveAll("waldata")
 }
+
+func TestWrappedWal_ConcurrentWrites(t *testing.T) {
+	w, err := NewWrappedWal(WalConfig{})
+	require.NoError(t, err, "Failed to create WrappedWal")
+	defer func() {
+		assert.NoError(t, w.Close(), "Failed to close WAL")
+		os.RemoveAll("waldata")
+	}()
+
+	const (
+		writers         = 8
+		writesPerWorker = 20
+	)
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for j := 0; j < writesPerWorker; j++ {
+				assert.NoError(t, w.Write("lastamount", decimal.NewFromInt(int64(worker*writesPerWorker+j))))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]struct{})
+	var prevIdx uint64
+	for m := range w.wal.Iterator() {
+		require.Greater(t, m.Idx, prevIdx, "WAL indexes must be strictly increasing")
+		prevIdx = m.Idx
+
+		var value decimal.Decimal
+		require.NoError(t, value.UnmarshalBinary(m.Value))
+		seen[value.String()] = struct{}{}
+	}
+
+	assert.Len(t, seen, writers*writesPerWorker, "every write must be stored exactly once")
+	assert.Equal(t, uint64(writers*writesPerWorker), w.wal.CurrentIndex())
+}
+
+func TestWrappedWal_IndexCollision(t *testing.T) {
+	w, err := NewWrappedWal(WalConfig{})
+	require.NoError(t, err, "Failed to create WrappedWal")
+	defer func() {
+		assert.NoError(t, w.Close(), "Failed to close WAL")
+		os.RemoveAll("waldata")
+	}()
+
+	// occupy the index the next Write would use
+	b, _ := decimal.NewFromInt(1).MarshalBinary()
+	require.NoError(t, w.wal.Write(w.wal.CurrentIndex()+2, "lastbuy", b))
+
+	price := decimal.NewFromInt(2)
+	require.NoError(t, w.Write("lastbuy", price), "Write must retry on index collision")
+
+	meta, err := w.GetLastBuyMeta()
+	require.NoError(t, err)
+	assert.True(t, price.Equal(meta.price), "Last buy price mismatch")
+}
+
+func TestCanonicalDecimal(t *testing.T) {
+	// weighted average price after several DCA buys
+	avg := decimal.RequireFromString("27123.456789012345678901234567890123")
+
+	canonical := canonicalDecimal(avg, walSignificantDigits)
+	assert.Equal(t, "27123.4567890123457", canonical.String())
+	assert.True(t, avg.Sub(canonical).Abs().LessThan(decimal.New(1, -13)),
+		"canonical value must equal original within canonical precision")
+
+	before, err := avg.MarshalBinary()
+	require.NoError(t, err)
+	after, err := canonical.MarshalBinary()
+	require.NoError(t, err)
+	assert.Less(t, len(after), len(before), "canonical encoding must be shorter")
+	t.Logf("encoded size before %d bytes, after %d bytes", len(before), len(after))
+
+	// trailing zeros are stripped, so equal values are encoded identically
+	a, _ := canonicalDecimal(decimal.RequireFromString("1.5000"), walSignificantDigits).MarshalBinary()
+	b, _ := canonicalDecimal(decimal.RequireFromString("1.5"), walSignificantDigits).MarshalBinary()
+	assert.Equal(t, a, b)
+
+	assert.True(t, decimal.Zero.Equal(canonicalDecimal(decimal.RequireFromString("0.000"), walSignificantDigits)))
+	assert.Equal(t, "-1200", canonicalDecimal(decimal.RequireFromString("-1200"), walSignificantDigits).String())
+	assert.Equal(t, "0.123", canonicalDecimal(decimal.RequireFromString("0.12300"), 3).String())
+	assert.Equal(t, "0.124", canonicalDecimal(decimal.RequireFromString("0.12351"), 3).String())
+}
+
+func TestWrappedWal_ReadsLongFormRecords(t *testing.T) {
+	w, err := NewWrappedWal(WalConfig{})
+	require.NoError(t, err, "Failed to create WrappedWal")
+	defer func() {
+		assert.NoError(t, w.Close(), "Failed to close WAL")
+		os.RemoveAll("waldata")
+	}()
+
+	// record written before canonical encoding was introduced
+	long := decimal.RequireFromString("27123.456789012345678901234567890")
+	b, err := long.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, w.wal.Write(w.wal.CurrentIndex()+1, "lastbuy", b))
+
+	meta, err := w.GetLastBuyMeta()
+	require.NoError(t, err)
+	assert.True(t, long.Equal(meta.price), "long-form record must be read as is")
+
+	require.NoError(t, w.Write("lastbuy", long))
+	meta, err = w.GetLastBuyMeta()
+	require.NoError(t, err)
+	assert.Equal(t, "27123.4567890123457", meta.price.String())
+}
+
+func TestResetWal(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	cfg := WalConfig{Dir: WalDir(entity.Pair{From: "BTC", To: "USDT"}, "")}
+	w, err := NewWrappedWal(cfg)
+	require.NoError(t, err)
+	require.NoError(t, w.Write("lastbuy", decimal.NewFromInt(100)))
+
+	// state of a running bot is not removed
+	require.ErrorIs(t, ResetWal(cfg.Dir), ErrWalLocked)
+	require.NoError(t, w.Close())
+
+	require.NoError(t, ResetWal(cfg.Dir))
+	require.NoDirExists(t, cfg.Dir)
+
+	w, err = NewWrappedWal(cfg)
+	require.NoError(t, err)
+	defer w.Close()
+	_, err = w.GetLastBuyMeta()
+	require.ErrorIs(t, err, ErrNoData)
+}
+
+func TestMigrateLegacyWal(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	w, err := NewWrappedWal(WalConfig{})
+	require.NoError(t, err)
+	require.NoError(t, w.Write("lastbuy", decimal.NewFromInt(100)))
+	require.NoError(t, w.Write("lastamount", decimal.NewFromInt(2)))
+	require.NoError(t, w.Close())
+
+	require.True(t, HasLegacyWal())
+	dir := WalDir(entity.Pair{From: "BTC", To: "USDT"}, "")
+	require.NoError(t, MigrateLegacyWal(dir))
+	require.False(t, HasLegacyWal())
+
+	w, err = NewWrappedWal(WalConfig{Dir: dir})
+	require.NoError(t, err)
+	defer w.Close()
+	meta, err := w.GetLastBuyMeta()
+	require.NoError(t, err)
+	assert.Equal(t, "100", meta.price.String())
+	assert.Equal(t, "2", meta.amount.String())
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// encryptedRecordHeader marks WAL record values encrypted with AES-GCM. Plaintext records have no header,
+// their first byte is the high byte of decimal exponent which never takes this value in practice,
+// so stores with both plaintext and encrypted records are read correctly.
+const encryptedRecordHeader byte = 0xE5
+
+const encryptionKeySize = 32
+
+var (
+	// ErrWrongEncryptionKey is returned when encrypted WAL record can't be decrypted with the configured key.
+	ErrWrongEncryptionKey = errors.New("failed to decrypt WAL record, wrong data encryption key")
+	// ErrNoEncryptionKey is returned when WAL contains encrypted records but no key is configured.
+	ErrNoEncryptionKey = errors.New("WAL record is encrypted, but data encryption key is not set")
+)
+
+// walCipher encrypts WAL record values, record keys and indexes stay plaintext.
+type walCipher struct {
+	aead cipher.AEAD
+}
+
+func newWalCipher(key []byte) (*walCipher, error) {
+	if len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("data encryption key must be %d bytes, got %d", encryptionKeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "error init data encryption cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "error init data encryption cipher")
+	}
+
+	return &walCipher{aead: aead}, nil
+}
+
+// seal encrypts value of the record, record key is authenticated so values can't be swapped between keys.
+func (c *walCipher) seal(key string, value []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize(), 1+c.aead.NonceSize()+len(value)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "error generate nonce")
+	}
+
+	res := append([]byte{encryptedRecordHeader}, nonce...)
+	return c.aead.Seal(res, nonce, value, []byte(key)), nil
+}
+
+// openRecord returns plaintext value of the record, plaintext records are returned as is.
+// Cipher may be nil if encryption is disabled.
+func openRecord(c *walCipher, key string, value []byte) ([]byte, error) {
+	if len(value) == 0 || value[0] != encryptedRecordHeader {
+		return value, nil
+	}
+	if c == nil {
+		return nil, errors.Wrapf(ErrNoEncryptionKey, "key %s", key)
+	}
+
+	value = value[1:]
+	if len(value) < c.aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted WAL record for key %s is too short", key)
+	}
+
+	plaintext, err := c.aead.Open(nil, value[:c.aead.NonceSize()], value[c.aead.NonceSize():], []byte(key))
+	if err != nil {
+		return nil, errors.Wrapf(ErrWrongEncryptionKey, "key %s", key)
+	}
+
+	return plaintext, nil
+}
+
+// ParseEncryptionKey decodes 32 bytes data encryption key from hex or base64 string.
+func ParseEncryptionKey(s string) ([]byte, error) {
+	if key, err := hex.DecodeString(s); err == nil && len(key) == encryptionKeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == encryptionKeySize {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("data encryption key must be %d bytes encoded as hex or base64", encryptionKeySize)
+}
+
+// SealData encrypts state kept outside of WAL with the data encryption key, name is authenticated
+// like the record key. Data is returned as is if key is empty.
+func SealData(key []byte, name string, data []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return data, nil
+	}
+	c, err := newWalCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.seal(name, data)
+}
+
+// OpenData decrypts state sealed by SealData, plaintext state is returned as is.
+func OpenData(key []byte, name string, data []byte) ([]byte, error) {
+	var c *walCipher
+	if len(key) > 0 {
+		var err error
+		if c, err = newWalCipher(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return openRecord(c, name, data)
+}
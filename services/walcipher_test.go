@@ -0,0 +1,111 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testEncryptionKey  = bytes.Repeat([]byte{7}, encryptionKeySize)
+	otherEncryptionKey = bytes.Repeat([]byte{8}, encryptionKeySize)
+)
+
+func TestWrappedWal_EncryptedRoundTrip(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	w, err := NewWrappedWal(WalConfig{EncryptionKey: testEncryptionKey})
+	require.NoError(t, err)
+
+	price := decimal.NewFromFloat(27123.45)
+	amount := decimal.NewFromFloat(0.015)
+	require.NoError(t, w.Write("lastbuy", price))
+	require.NoError(t, w.Write("lastamount", amount))
+
+	plaintext, err := price.MarshalBinary()
+	require.NoError(t, err)
+	for m := range w.wal.Iterator() {
+		assert.Equal(t, encryptedRecordHeader, m.Value[0], "record must be encrypted")
+		assert.NotContains(t, string(m.Value), string(plaintext))
+	}
+	require.NoError(t, w.Close())
+
+	w, err = NewWrappedWal(WalConfig{EncryptionKey: testEncryptionKey})
+	require.NoError(t, err)
+	defer w.Close()
+
+	meta, err := w.GetLastBuyMeta()
+	require.NoError(t, err)
+	assert.True(t, price.Equal(meta.price))
+	assert.True(t, amount.Equal(meta.amount))
+}
+
+func TestWrappedWal_EncryptedWrongKey(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	w, err := NewWrappedWal(WalConfig{EncryptionKey: testEncryptionKey})
+	require.NoError(t, err)
+	require.NoError(t, w.Write("lastbuy", decimal.NewFromInt(100)))
+	require.NoError(t, w.Close())
+
+	w, err = NewWrappedWal(WalConfig{EncryptionKey: otherEncryptionKey})
+	require.NoError(t, err)
+	_, err = w.GetLastBuyMeta()
+	require.ErrorIs(t, err, ErrWrongEncryptionKey)
+	require.NoError(t, w.Close())
+
+	w, err = NewWrappedWal(WalConfig{})
+	require.NoError(t, err)
+	_, err = w.GetLastBuyMeta()
+	require.ErrorIs(t, err, ErrNoEncryptionKey)
+	require.NoError(t, w.Close())
+
+	_, err = NewWrappedWal(WalConfig{EncryptionKey: []byte("short")})
+	require.Error(t, err)
+}
+
+func TestWrappedWal_EncryptionMigration(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	// plaintext store written before encryption was enabled
+	w, err := NewWrappedWal(WalConfig{})
+	require.NoError(t, err)
+	require.NoError(t, w.Write("lastbuy", decimal.NewFromInt(100)))
+	require.NoError(t, w.Write("lastamount", decimal.NewFromInt(2)))
+	require.NoError(t, w.Close())
+
+	w, err = NewWrappedWal(WalConfig{EncryptionKey: testEncryptionKey})
+	require.NoError(t, err)
+	defer w.Close()
+
+	meta, err := w.GetLastBuyMeta()
+	require.NoError(t, err)
+	assert.True(t, decimal.NewFromInt(100).Equal(meta.price))
+	assert.True(t, decimal.NewFromInt(2).Equal(meta.amount))
+
+	// new records are encrypted, old ones are still read
+	require.NoError(t, w.Write("lastbuy", decimal.NewFromInt(90)))
+	meta, err = w.GetLastBuyMeta()
+	require.NoError(t, err)
+	assert.True(t, decimal.NewFromInt(90).Equal(meta.price))
+	assert.True(t, decimal.NewFromInt(2).Equal(meta.amount))
+}
+
+func TestParseEncryptionKey(t *testing.T) {
+	key, err := ParseEncryptionKey(hex.EncodeToString(testEncryptionKey))
+	require.NoError(t, err)
+	require.Equal(t, testEncryptionKey, key)
+
+	key, err = ParseEncryptionKey(base64.StdEncoding.EncodeToString(testEncryptionKey))
+	require.NoError(t, err)
+	require.Equal(t, testEncryptionKey, key)
+
+	_, err = ParseEncryptionKey("deadbeef")
+	require.Error(t, err)
+}
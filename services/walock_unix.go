@@ -0,0 +1,29 @@
+//go:build unix
+
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// lockDir takes exclusive lock of WAL directory. The lock is released by unlock or when the process exits,
+// so it doesn't outlive crashed bots.
+func lockDir(dir string) (unlock func() error, err error) {
+	f, err := os.OpenFile(filepath.Join(dir, walLockFile), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "error open wal lock file")
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errors.Wrapf(ErrWalLocked, "dir %s", dir)
+		}
+		return nil, errors.Wrap(err, "error lock wal dir")
+	}
+
+	return f.Close, nil
+}
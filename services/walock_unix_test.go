@@ -0,0 +1,35 @@
+//go:build unix
+
+package services
+
+import (
+	"os"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"github.com/vadiminshakov/marti/entity"
+)
+
+// Two bots of the same pair can't share the WAL, so the second one never runs the initial buy
+// without seeing the position of the first one.
+func TestWrappedWal_SecondWriterIsRefused(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	cfg := WalConfig{Dir: WalDir(entity.Pair{From: "BTC", To: "USDT"}, "")}
+	w, err := NewWrappedWal(cfg)
+	require.NoError(t, err)
+	require.NoError(t, w.Write("lastbuy", decimal.NewFromInt(100)))
+
+	_, err = NewWrappedWal(cfg)
+	require.ErrorIs(t, err, ErrWalLocked)
+
+	// the lock is released with the WAL, the next bot sees the position
+	require.NoError(t, w.Close())
+	w, err = NewWrappedWal(cfg)
+	require.NoError(t, err)
+	defer w.Close()
+	meta, err := w.GetLastBuyMeta()
+	require.NoError(t, err)
+	require.Equal(t, "100", meta.price.String())
+}
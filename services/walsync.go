@@ -0,0 +1,93 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// WalSyncMode defines when WAL writes are synced to disk.
+type WalSyncMode string
+
+const (
+	// WalSyncAlways syncs every write, it is the only mode suitable for live trading.
+	WalSyncAlways WalSyncMode = "always"
+	// WalSyncInterval syncs every WalConfig.SyncEvery writes or WalConfig.SyncInterval, and on Close.
+	WalSyncInterval WalSyncMode = "interval"
+	// WalSyncNever leaves syncing to OS, use it for simulations only.
+	WalSyncNever WalSyncMode = "never"
+)
+
+const (
+	defaultWalSyncEvery    = 100
+	defaultWalSyncInterval = time.Second
+)
+
+func (m WalSyncMode) validate() error {
+	switch m {
+	case "", WalSyncAlways, WalSyncInterval, WalSyncNever:
+		return nil
+	default:
+		return fmt.Errorf("unknown WAL sync mode %q, expected %s, %s or %s", m, WalSyncAlways, WalSyncInterval, WalSyncNever)
+	}
+}
+
+// runFlusher syncs pending writes every interval until Close.
+func (w *WrappedWal) runFlusher(interval time.Duration) {
+	defer close(w.flusherDone)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			w.mu.Lock()
+			if err := w.syncPending(); err != nil {
+				// the next write or Close retries the sync
+				w.syncErr = err
+			}
+			w.mu.Unlock()
+		case <-w.stopFlusher:
+			return
+		}
+	}
+}
+
+// syncPending syncs WAL files to disk if there are unsynced writes, must be called with mu locked.
+// gowal doesn't expose its files, so every file of the WAL directory is synced, which flushes the same inodes.
+func (w *WrappedWal) syncPending() error {
+	if w.pending == 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return errors.Wrap(err, "error read wal dir")
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		if err := syncFile(filepath.Join(w.dir, e.Name())); err != nil {
+			return err
+		}
+	}
+
+	w.pending = 0
+	w.syncErr = nil
+
+	return nil
+}
+
+func syncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "error open %s for sync", path)
+	}
+	defer f.Close()
+
+	return errors.Wrapf(f.Sync(), "error sync %s", path)
+}
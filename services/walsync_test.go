@@ -0,0 +1,92 @@
+package services
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrappedWal_IntervalSyncOnClose(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	w, err := NewWrappedWal(WalConfig{SyncMode: WalSyncInterval, SyncEvery: 1000, SyncInterval: time.Hour})
+	require.NoError(t, err)
+
+	for i := 1; i <= 10; i++ {
+		require.NoError(t, w.Write("lastbuy", decimal.NewFromInt(int64(i))))
+	}
+	assert.Equal(t, 10, w.pending, "writes must not be synced before threshold")
+
+	require.NoError(t, w.Close())
+	assert.Equal(t, 0, w.pending, "Close must sync pending writes")
+
+	w, err = NewWrappedWal(WalConfig{})
+	require.NoError(t, err)
+	defer w.Close()
+
+	meta, err := w.GetLastBuyMeta()
+	require.NoError(t, err)
+	assert.True(t, decimal.NewFromInt(10).Equal(meta.price))
+}
+
+func TestWrappedWal_IntervalSyncEvery(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	w, err := NewWrappedWal(WalConfig{SyncMode: WalSyncInterval, SyncEvery: 3, SyncInterval: time.Hour})
+	require.NoError(t, err)
+	defer w.Close()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, w.Write("lastbuy", decimal.NewFromInt(int64(i))))
+	}
+	assert.Equal(t, 0, w.pending)
+
+	require.NoError(t, w.Write("lastbuy", decimal.NewFromInt(4)))
+	assert.Equal(t, 1, w.pending)
+}
+
+func TestWrappedWal_IntervalSyncByTimer(t *testing.T) {
+	defer os.RemoveAll("waldata")
+
+	w, err := NewWrappedWal(WalConfig{SyncMode: WalSyncInterval, SyncEvery: 1000, SyncInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+	defer w.Close()
+
+	require.NoError(t, w.Write("lastbuy", decimal.NewFromInt(1)))
+	require.Eventually(t, func() bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.pending == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestWrappedWal_InvalidSyncMode(t *testing.T) {
+	_, err := NewWrappedWal(WalConfig{SyncMode: "sometimes"})
+	require.ErrorContains(t, err, "unknown WAL sync mode")
+}
+
+func BenchmarkWrappedWalWrite(b *testing.B) {
+	for _, mode := range []WalSyncMode{WalSyncAlways, WalSyncInterval, WalSyncNever} {
+		b.Run(string(mode), func(b *testing.B) {
+			defer os.RemoveAll("waldata")
+
+			w, err := NewWrappedWal(WalConfig{SyncMode: mode})
+			require.NoError(b, err)
+
+			value := decimal.NewFromFloat(27123.45)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := w.Write("lastbuy", value); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+
+			require.NoError(b, w.Close())
+		})
+	}
+}
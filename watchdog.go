@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vadiminshakov/marti/entity"
+	"github.com/vadiminshakov/marti/services"
+)
+
+// errTradeHung is returned by the bot when trade cycles hang too many times in a row, so the bot is recreated
+// with fresh state and connections.
+var errTradeHung = errors.New("trade cycles hang")
+
+type tradeResult struct {
+	te  *entity.TradeEvent
+	err error
+}
+
+// watchdog runs trade cycles with a deadline. Exchange calls can't be interrupted, so a hung cycle keeps running
+// in background and no new cycle is started until it completes, the bot state is never changed concurrently.
+// The cycle limiter slot of a hung cycle is released, so it doesn't block cycles of the other bots.
+type watchdog struct {
+	timeout  time.Duration
+	maxHangs int
+	// hangs is the number of consecutive deadlines the running cycle has missed
+	hangs int
+	// status counts missed deadlines since the bot is started
+	status  *services.BotStatus
+	limiter *cycleLimiter
+
+	pending   chan tradeResult
+	slot      *cycleSlot
+	startedAt time.Time
+}
+
+func newWatchdog(timeout time.Duration, maxHangs int, limiter *cycleLimiter, status *services.BotStatus) *watchdog {
+	return &watchdog{timeout: timeout, maxHangs: maxHangs, limiter: limiter, status: status}
+}
+
+// run starts trade cycle, or keeps waiting for the hung one, at most for timeout. If the deadline is missed
+// hung is true, and the result is returned by one of the next calls once the cycle completes.
+func (w *watchdog) run(ctx context.Context, trade func() (*entity.TradeEvent, error)) (te *entity.TradeEvent, hung bool, err error) {
+	if w.pending == nil {
+		w.pending = make(chan tradeResult, 1)
+		w.slot = w.limiter.slot()
+		w.startedAt = time.Now()
+		go func(res chan<- tradeResult, slot *cycleSlot) {
+			slot.acquire()
+			te, err := trade()
+			slot.release()
+			res <- tradeResult{te: te, err: err}
+		}(w.pending, w.slot)
+	}
+
+	timer := time.NewTimer(w.timeout)
+	defer timer.Stop()
+	select {
+	case res := <-w.pending:
+		w.pending = nil
+		w.hangs = 0
+		return res.te, false, res.err
+	case <-timer.C:
+		w.hangs++
+		w.status.RecordHang()
+		w.slot.release()
+		return nil, true, nil
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+// running returns how long the current cycle is running.
+func (w *watchdog) running() time.Duration {
+	if w.pending == nil {
+		return 0
+	}
+
+	return time.Since(w.startedAt)
+}
+
+// wait blocks until the hung cycle completes and returns its result, ok is false if no cycle is running.
+func (w *watchdog) wait() (te *entity.TradeEvent, ok bool, err error) {
+	if w.pending == nil {
+		return nil, false, nil
+	}
+
+	res := <-w.pending
+	w.pending = nil
+	return res.te, true, res.err
+}
+
+// escalate returns true if the cycle hangs too long to wait for it anymore.
+func (w *watchdog) escalate() bool {
+	return w.hangs >= w.maxHangs
+}
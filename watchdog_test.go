@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/vadiminshakov/marti/entity"
+	"github.com/vadiminshakov/marti/services"
+	anomalymock "github.com/vadiminshakov/marti/services/anomalydetector/mock"
+	detectormock "github.com/vadiminshakov/marti/services/detector/mock"
+	"go.uber.org/zap"
+)
+
+func TestWatchdog(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int32
+	hangingTrade := func() (*entity.TradeEvent, error) {
+		calls.Add(1)
+		<-release
+		return &entity.TradeEvent{Action: entity.ActionBuy}, nil
+	}
+
+	status := &services.BotStatus{}
+	wd := newWatchdog(10*time.Millisecond, 3, nil, status)
+	for i := 1; i <= 3; i++ {
+		te, hung, err := wd.run(context.Background(), hangingTrade)
+		require.NoError(t, err)
+		require.True(t, hung, "deadline of hung cycle must be detected")
+		require.Nil(t, te)
+		require.Equal(t, i, wd.hangs)
+		require.Equal(t, i == 3, wd.escalate(), "hung cycle must be escalated after max hangs")
+	}
+	require.EqualValues(t, 1, calls.Load(), "new cycle must not start while the hung one is running")
+	require.Positive(t, wd.running())
+
+	// late result of the hung cycle is not lost
+	close(release)
+	te, hung, err := wd.run(context.Background(), hangingTrade)
+	require.NoError(t, err)
+	require.False(t, hung)
+	require.Equal(t, entity.ActionBuy, te.Action)
+	require.False(t, wd.escalate())
+	require.EqualValues(t, 3, status.Hangs())
+
+	// loop continues with new cycles
+	te, hung, err = wd.run(context.Background(), hangingTrade)
+	require.NoError(t, err)
+	require.False(t, hung)
+	require.NotNil(t, te)
+	require.EqualValues(t, 2, calls.Load())
+	require.Zero(t, wd.running())
+}
+
+func TestWatchdogContextDone(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	wd := newWatchdog(time.Hour, 3, nil, &services.BotStatus{})
+	_, _, err := wd.run(ctx, func() (*entity.TradeEvent, error) {
+		<-release
+		return nil, nil
+	})
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+// blockingPricer returns price once it is released.
+type blockingPricer struct {
+	release chan struct{}
+}
+
+func (p *blockingPricer) GetPrice(_ entity.Pair) (decimal.Decimal, error) {
+	<-p.release
+	return decimal.NewFromInt(100), nil
+}
+
+// countingTrader counts orders.
+type countingTrader struct {
+	orders atomic.Int32
+}
+
+func (t *countingTrader) Buy(_ decimal.Decimal) error {
+	t.orders.Add(1)
+	return nil
+}
+
+func (t *countingTrader) Sell(_ decimal.Decimal) error {
+	t.orders.Add(1)
+	return nil
+}
+
+func (t *countingTrader) GetBalance(_ string) (decimal.Decimal, error) {
+	return decimal.NewFromInt(1000), nil
+}
+
+func TestDrainHungCycle(t *testing.T) {
+	pair := entity.Pair{From: "BTC", To: "USDT"}
+	pricer := &blockingPricer{release: make(chan struct{})}
+	trader := &countingTrader{}
+
+	detector := detectormock.NewDetector(t)
+	detector.On("NeedAction", mock.Anything).Return(entity.ActionBuy, nil)
+	anomalyDetector := anomalymock.NewAnomalyDetector(t)
+	anomalyDetector.On("IsAnomaly", mock.Anything).Return(false)
+
+	ts, err := services.NewTradeService(zap.NewNop(), pair, decimal.NewFromInt(5), pricer, detector, trader,
+		anomalyDetector, nil, services.WalConfig{Dir: t.TempDir()})
+	require.NoError(t, err)
+	defer ts.Close()
+
+	limiter := newCycleLimiter(1)
+	wd := newWatchdog(10*time.Millisecond, 1, limiter, &services.BotStatus{})
+	_, hung, err := wd.run(context.Background(), ts.Trade)
+	require.NoError(t, err)
+	require.True(t, hung)
+	require.True(t, wd.escalate())
+
+	// hung cycle doesn't block cycles of the other bots
+	require.Empty(t, limiter.slots, "slot of the hung cycle must be released")
+	_, err = limiter.wrap(func() (*entity.TradeEvent, error) { return nil, nil })()
+	require.NoError(t, err)
+
+	drained := make(chan struct{})
+	go func() {
+		drainHungCycle(zap.NewNop(), ts, wd, nil, pair)
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		t.Fatal("bot must not be recreated while the hung cycle is running")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// the cycle wakes up after it is abandoned, it must not place the order
+	close(pricer.release)
+	<-drained
+	require.Zero(t, trader.orders.Load())
+	require.Empty(t, limiter.slots, "slot of the hung cycle must be freed")
+	require.True(t, ts.Position().IsZero())
+}
+
+// TestHaltedWhileCycleHangs must be run with -race, the symbol status check changes the halt flag
+// while the abandoned cycle reads it.
+func TestHaltedWhileCycleHangs(t *testing.T) {
+	pair := entity.Pair{From: "BTC", To: "USDT"}
+	pricer := &blockingPricer{release: make(chan struct{})}
+	trader := &countingTrader{}
+
+	detector := detectormock.NewDetector(t)
+	detector.On("NeedAction", mock.Anything).Return(entity.ActionBuy, nil)
+	anomalyDetector := anomalymock.NewAnomalyDetector(t)
+	anomalyDetector.On("IsAnomaly", mock.Anything).Return(false)
+
+	ts, err := services.NewTradeService(zap.NewNop(), pair, decimal.NewFromInt(5), pricer, detector, trader,
+		anomalyDetector, nil, services.WalConfig{Dir: t.TempDir()})
+	require.NoError(t, err)
+	defer ts.Close()
+
+	wd := newWatchdog(10*time.Millisecond, 3, nil, &services.BotStatus{})
+	_, hung, err := wd.run(context.Background(), ts.Trade)
+	require.NoError(t, err)
+	require.True(t, hung)
+
+	close(pricer.release)
+	for i := range 1000 {
+		if ts.Halted() != (i%2 == 0) {
+			ts.SetHalted(i%2 == 0)
+		}
+	}
+	_, ok, _ := wd.wait()
+	require.True(t, ok)
+}